@@ -0,0 +1,150 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+)
+
+// twikFuncType is the type of functions defined in twik with func, and of
+// the functions returned by Func.
+var twikFuncType = reflect.TypeOf((func([]interface{}) (interface{}, error))(nil))
+
+// callState is shared by the callbacks passed in a single call of an
+// exported function. It collects the errors of callbacks that have no
+// error result.
+type callState struct {
+	mu   sync.Mutex
+	done bool  // the function has returned
+	err  error // the first error of a callback
+	late func(error)
+}
+
+// lateError handles err, which a callback returned after the function has
+// returned. Without a handler, err is written to os.Stderr.
+func (cs *callState) lateError(err error) {
+	if cs != nil && cs.late != nil {
+		cs.late(err)
+		return
+	}
+	fmt.Fprintln(os.Stderr, "twik: callback:", err)
+}
+
+// fail records err for the call, and reports whether this was possible.
+func (cs *callState) fail(err error) bool {
+	if cs == nil {
+		return false
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.done {
+		return false
+	}
+	if cs.err == nil {
+		cs.err = err
+	}
+	return true
+}
+
+// finish marks the call as done and returns the first error of a callback.
+func (cs *callState) finish() error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.done = true
+	return cs.err
+}
+
+// callback adapts the twik function fn to the Go function type ft.
+//
+// The arguments are passed to fn as they are. The result of fn is converted
+// to the results of ft in the same way as Func converts results to a twik
+// value, but in reverse: nil for no results, the value itself for one,
+// nil or the value for (value, ok), and a list otherwise. Each value is
+// converted to the result type like an argument to Func.
+//
+// If fn fails or returns a value of the wrong type, the error is returned
+// as the last result if ft has an error result. Otherwise the callback
+// returns zero values, and the error is returned by the Func adapter that
+// the callback was passed to, once the function has returned. If it has
+// returned already, the error is passed to the CallbackError hook of the
+// Executer, or written to its standard error. A Go function
+// that keeps a callback to call it later should therefore use a function
+// type with an error result.
+func callback(cs *callState, ft reflect.Type, fn func([]interface{}) (interface{}, error)) reflect.Value {
+	out, hasErr := results(ft)
+	fail := func(err error) []reflect.Value {
+		vo := make([]reflect.Value, out, out+1)
+		for i := 0; i < out; i++ {
+			vo[i] = reflect.Zero(ft.Out(i))
+		}
+		if hasErr {
+			return append(vo, reflect.ValueOf(&err).Elem())
+		}
+		if !cs.fail(err) {
+			cs.lateError(err)
+		}
+		return vo
+	}
+	return reflect.MakeFunc(ft, func(vi []reflect.Value) []reflect.Value {
+		args := make([]interface{}, len(vi))
+		for i, v := range vi {
+			args[i] = v.Interface()
+		}
+		if ft.IsVariadic() {
+			last := vi[len(vi)-1]
+			args = args[:len(args)-1]
+			for i := 0; i < last.Len(); i++ {
+				args = append(args, last.Index(i).Interface())
+			}
+		}
+		res, err := fn(args)
+		if err != nil {
+			return fail(err)
+		}
+
+		var xs []interface{}
+		switch {
+		case out == 0:
+		case out == 1:
+			xs = []interface{}{res}
+		case isOk(ft, out):
+			xs = []interface{}{res, res != nil}
+			if res == nil {
+				xs[0] = reflect.Zero(ft.Out(0)).Interface()
+			}
+		default:
+			var ok bool
+			xs, ok = res.([]interface{})
+			if !ok || len(xs) != out {
+				return fail(fmt.Errorf("callback returned %v but need a list of %d values", res, out))
+			}
+		}
+		vo := make([]reflect.Value, len(xs), out+1)
+		for i, x := range xs {
+			v, ok := argValue(cs, ft.Out(i), x)
+			if !ok {
+				return fail(fmt.Errorf("callback returned type %s but need type %s", typeOf(x), typeName(ft.Out(i))))
+			}
+			vo[i] = v
+		}
+		if hasErr {
+			vo = append(vo, reflect.Zero(errorType))
+		}
+		return vo
+	})
+}
+
+// call calls vf with the arguments vi and converts the results with ret.
+// An error from a callback without an error result is returned as the error.
+func call(cs *callState, vf reflect.Value, vi []reflect.Value, ret func([]reflect.Value) (interface{}, error)) (interface{}, error) {
+	vo := vf.Call(vi)
+	if err := cs.finish(); err != nil {
+		return nil, err
+	}
+	return ret(vo)
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goulash/twikutil"
+)
+
+const debugHelp = `Commands:
+  c, continue        run until the next breakpoint
+  s, step            step into the next form
+  n, next            step over the current form
+  o, out             step out of the current form
+  b [FILE:]LINE      set a breakpoint
+  d [FILE:]LINE      delete a breakpoint
+  bt, where          print the forms being evaluated
+  v, vars            print the variables in scope
+  p EXPR             evaluate an expression in the current scope
+  q, quit            abort the script
+`
+
+// breakpoints is a list of FILE:LINE positions given as flags.
+type breakpoints []twikutil.Position
+
+func (bs *breakpoints) String() string {
+	xs := make([]string, len(*bs))
+	for i, b := range *bs {
+		xs[i] = fmt.Sprintf("%s:%d", b.File, b.Line)
+	}
+	return strings.Join(xs, ",")
+}
+
+func (bs *breakpoints) Set(s string) error {
+	p, err := parseBreakpoint(s, "")
+	if err != nil {
+		return err
+	}
+	*bs = append(*bs, p)
+	return nil
+}
+
+// parseBreakpoint parses [FILE:]LINE, where file is the default FILE.
+func parseBreakpoint(s, file string) (twikutil.Position, error) {
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		file, s = s[:i], s[i+1:]
+	}
+	line, err := strconv.Atoi(s)
+	if err != nil || line < 1 || file == "" {
+		return twikutil.Position{}, fmt.Errorf("invalid breakpoint: %s", s)
+	}
+	return twikutil.Position{File: file, Line: line}, nil
+}
+
+// debugFile runs file with a debugger that reads commands from in, and
+// pauses at the first form.
+func debugFile(e *twikutil.Executer, file string, bps breakpoints, in io.Reader, out io.Writer) error {
+	sc := bufio.NewScanner(in)
+	var d *twikutil.Debugger
+	d = e.StartDebugger(func(f *twikutil.Frame) twikutil.StepMode {
+		fmt.Fprintf(out, "%s: %s\n", f.Pos, f.Code())
+		for {
+			fmt.Fprint(out, "(debug) ")
+			if !sc.Scan() {
+				fmt.Fprintln(out)
+				return twikutil.Abort
+			}
+			cmd, arg := sc.Text(), ""
+			cmd = strings.TrimSpace(cmd)
+			if i := strings.IndexByte(cmd, ' '); i >= 0 {
+				cmd, arg = cmd[:i], strings.TrimSpace(cmd[i+1:])
+			}
+			switch cmd {
+			case "c", "continue":
+				return twikutil.Continue
+			case "s", "step":
+				return twikutil.StepIn
+			case "n", "next":
+				return twikutil.StepOver
+			case "o", "out":
+				return twikutil.StepOut
+			case "q", "quit":
+				return twikutil.Abort
+			case "b", "d":
+				p, err := parseBreakpoint(arg, f.Pos.File)
+				if err != nil {
+					fmt.Fprintln(out, err)
+				} else if cmd == "b" {
+					d.SetBreakpoint(p.File, p.Line)
+				} else {
+					d.ClearBreakpoint(p.File, p.Line)
+				}
+			case "bt", "where":
+				stack := d.Stack()
+				for i := len(stack) - 1; i >= 0; i-- {
+					fmt.Fprintf(out, "%s: %s\n", stack[i].Pos, stack[i].Code())
+				}
+			case "v", "vars":
+				vars := f.Variables()
+				names := make([]string, 0, len(vars))
+				for k := range vars {
+					names = append(names, k)
+				}
+				sort.Strings(names)
+				for _, k := range names {
+					fmt.Fprintf(out, "%s = %#v\n", k, vars[k])
+				}
+			case "p":
+				v, err := f.Eval(arg)
+				if err != nil {
+					fmt.Fprintln(out, err)
+				} else {
+					fmt.Fprintf(out, "%#v\n", v)
+				}
+			case "":
+			default:
+				fmt.Fprint(out, debugHelp)
+			}
+		}
+	})
+	for _, b := range bps {
+		d.SetBreakpoint(b.File, b.Line)
+	}
+	if len(bps) == 0 {
+		d.Step(twikutil.StepIn)
+	}
+	_, err := e.Exec(file)
+	return err
+}
@@ -0,0 +1,36 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDebug(z *testing.T) {
+	file := filepath.Join(z.TempDir(), "test.twik")
+	code := "(var x 1)\n(var y (+ x 1))\n(set x (upper \"a\"))\n"
+	if err := os.WriteFile(file, []byte(code), 0644); err != nil {
+		z.Fatal(err)
+	}
+	in := strings.NewReader("n\nvars\nb 3\nc\np (+ x y)\nbt\nc\n")
+	var out bytes.Buffer
+	if err := debug([]string{file}, in, &out); err != nil {
+		z.Fatal(err)
+	}
+	want := file + `:1:1: (var x 1)
+(debug) ` + file + `:2:1: (var y (+ x 1))
+(debug) x = 1
+(debug) (debug) ` + file + `:3:1: (set x (upper "a"))
+(debug) 3
+(debug) ` + file + `:3:1: (set x (upper "a"))
+(debug) `
+	if out.String() != want {
+		z.Errorf("output:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+// Command twik runs and debugs twik scripts.
+//
+// Usage:
+//
+//  twik run FILE
+//  twik debug [-b FILE:LINE]... FILE
+//  twik lsp
+//
+// Scripts are run with the functions of the stdlib package and the
+// introspection functions, such as help. Lines starting with # are
+// preprocessor directives, such as #include.
+//
+// The lsp command runs a language server for editors on standard input
+// and output.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/goulash/pre"
+	"github.com/goulash/twikutil"
+	"github.com/goulash/twikutil/lsp"
+	"github.com/goulash/twikutil/stdlib"
+	"gopkg.in/twik.v1"
+)
+
+const usage = `Usage:
+  twik run FILE                      run a script
+  twik debug [-b FILE:LINE]... FILE  debug a script
+  twik lsp                           run a language server on stdin and stdout
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "run":
+		err = run(args)
+	case "debug":
+		err = debug(args, os.Stdin, os.Stdout)
+	case "lsp":
+		err = serveLSP(os.Stdin, os.Stdout)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "twik: unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// newExecuter returns an Executer for scripts run by the command.
+func newExecuter() *twikutil.Executer {
+	e := twikutil.New(func(*twik.Scope) twikutil.FuncMap { return stdlib.All })
+	e.Export(e.Introspection())
+	e.PreProcessor = pre.New()
+	return e
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("twik run: need exactly one file")
+	}
+	_, err := newExecuter().Exec(fs.Arg(0))
+	return err
+}
+
+func debug(args []string, in io.Reader, out io.Writer) error {
+	var bps breakpoints
+	fs := flag.NewFlagSet("debug", flag.ExitOnError)
+	fs.Var(&bps, "b", "set a breakpoint at `FILE:LINE`")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("twik debug: need exactly one file")
+	}
+	return debugFile(newExecuter(), fs.Arg(0), bps, in, out)
+}
+
+func serveLSP(in io.Reader, out io.Writer) error {
+	funcs := make(twikutil.FuncMap)
+	funcs.Import(stdlib.All)
+	funcs.Import(newExecuter().Introspection())
+	return lsp.NewServer(funcs, nil).Serve(in, out)
+}
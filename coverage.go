@@ -0,0 +1,339 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/twik.v1"
+)
+
+// Coverage records which forms of the scripts run by an Executer are
+// evaluated, and how often.
+//
+// Coverage is started with StartCoverage and records until it is stopped.
+// Only the scripts that are run while recording are part of the coverage;
+// a form that is never evaluated has a count of zero. Branches of if that
+// are atoms, such as the 1 in (if c 1 2), count as forms. Positions are
+// those of the original files, if the PreProcessor included any.
+type Coverage struct {
+	e *Executer
+
+	mu      sync.Mutex
+	counts  map[coverKey]int64
+	sources map[string]string
+}
+
+// CoverBlock is a form in a script and how often it was evaluated.
+type CoverBlock struct {
+	Start Position // opening parenthesis
+	End   Position // closing parenthesis
+	Count int64
+}
+
+type coverKey struct {
+	start, end Position
+}
+
+// StartCoverage starts recording coverage for e and returns the coverage.
+func (e *Executer) StartCoverage() *Coverage {
+	c := NewCoverage()
+	c.e = e
+	e.addProbe(c, c.probe)
+	return c
+}
+
+// NewCoverage returns empty coverage, which coverage can be merged into.
+func NewCoverage() *Coverage {
+	return &Coverage{
+		counts:  make(map[coverKey]int64),
+		sources: make(map[string]string),
+	}
+}
+
+// Stop stops recording coverage.
+func (c *Coverage) Stop() {
+	if c.e != nil {
+		c.e.removeProbe(c)
+		c.e = nil
+	}
+}
+
+func (c *Coverage) probe(f *form, _ *twik.Scope, eval func() (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	c.counts[coverKey{f.pos, f.end}]++
+	c.mu.Unlock()
+	return eval()
+}
+
+func (c *Coverage) track(forms []*form, p *Program) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range forms {
+		k := coverKey{f.pos, f.end}
+		if _, ok := c.counts[k]; !ok {
+			c.counts[k] = 0
+		}
+	}
+	if p.root == nil {
+		c.sources[p.name] = p.code
+	}
+}
+
+// Merge adds the counts of o to c.
+func (c *Coverage) Merge(o *Coverage) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, n := range o.counts {
+		c.counts[k] += n
+	}
+	for k, v := range o.sources {
+		c.sources[k] = v
+	}
+}
+
+// Blocks returns the forms of all scripts, sorted by position.
+func (c *Coverage) Blocks() []CoverBlock {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bs := make([]CoverBlock, 0, len(c.counts))
+	for k, n := range c.counts {
+		bs = append(bs, CoverBlock{k.start, k.end, n})
+	}
+	sort.Slice(bs, func(i, j int) bool {
+		a, b := bs[i], bs[j]
+		if a.Start != b.Start {
+			return before(a.Start, b.Start)
+		}
+		return before(a.End, b.End)
+	})
+	return bs
+}
+
+func before(a, b Position) bool {
+	if a.File != b.File {
+		return a.File < b.File
+	}
+	if a.Line != b.Line {
+		return a.Line < b.Line
+	}
+	return a.Column < b.Column
+}
+
+// Percent returns the percentage of forms that were evaluated.
+func (c *Coverage) Percent() float64 {
+	bs := c.Blocks()
+	if len(bs) == 0 {
+		return 0
+	}
+	var n int
+	for _, b := range bs {
+		if b.Count > 0 {
+			n++
+		}
+	}
+	return 100 * float64(n) / float64(len(bs))
+}
+
+// fileLines contains the count of each line of a file on which a form
+// starts. If several forms start on a line, its count is the lowest of
+// theirs, so that the line is only covered if all of them are.
+type fileLines struct {
+	file    string
+	lines   []int
+	counts  map[int]int64
+	partial map[int]bool
+}
+
+func (c *Coverage) files() []*fileLines {
+	var fs []*fileLines
+	for _, b := range c.Blocks() {
+		if len(fs) == 0 || fs[len(fs)-1].file != b.Start.File {
+			fs = append(fs, &fileLines{
+				file:    b.Start.File,
+				counts:  make(map[int]int64),
+				partial: make(map[int]bool),
+			})
+		}
+		f := fs[len(fs)-1]
+		line := b.Start.Line
+		n, ok := f.counts[line]
+		switch {
+		case !ok:
+			f.lines = append(f.lines, line)
+			f.counts[line] = b.Count
+		case (n == 0) != (b.Count == 0):
+			f.partial[line] = true
+			fallthrough
+		default:
+			if b.Count < n {
+				f.counts[line] = b.Count
+			}
+		}
+	}
+	return fs
+}
+
+// WriteGoCover writes the coverage to w in the format of Go coverage
+// profiles, in count mode. Unlike in Go, blocks can be nested.
+func (c *Coverage) WriteGoCover(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "mode: count")
+	for _, b := range c.Blocks() {
+		fmt.Fprintf(bw, "%s:%d.%d,%d.%d 1 %d\n",
+			b.Start.File, b.Start.Line, b.Start.Column, b.End.Line, b.End.Column+1, b.Count)
+	}
+	return bw.Flush()
+}
+
+// ReadCoverage reads coverage in the format written by WriteGoCover.
+func ReadCoverage(r io.Reader) (*Coverage, error) {
+	c := NewCoverage()
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		var k coverKey
+		var stmts int
+		var count int64
+		i := strings.LastIndex(line, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: invalid coverage: %s", n, line)
+		}
+		k.start.File, k.end.File = line[:i], line[:i]
+		_, err := fmt.Sscanf(line[i+1:], "%d.%d,%d.%d %d %d",
+			&k.start.Line, &k.start.Column, &k.end.Line, &k.end.Column, &stmts, &count)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid coverage: %s", n, line)
+		}
+		k.end.Column--
+		c.counts[k] += count
+	}
+	return c, s.Err()
+}
+
+// WriteLcov writes the line coverage to w in the lcov tracefile format.
+// A line is listed if a form starts on it, with the lowest count of
+// those forms.
+func (c *Coverage) WriteLcov(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range c.files() {
+		fmt.Fprintf(bw, "TN:\nSF:%s\n", f.file)
+		var hit int
+		for _, l := range f.lines {
+			n := f.counts[l]
+			if n > 0 {
+				hit++
+			}
+			fmt.Fprintf(bw, "DA:%d,%d\n", l, n)
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", len(f.lines), hit)
+	}
+	return bw.Flush()
+}
+
+// WriteHTML writes an HTML page to w that shows the sources of the scripts
+// with the lines that were evaluated, partly evaluated, and not evaluated
+// marked in different colors.
+//
+// Scripts that were not preprocessed are shown as they were run. Other
+// files are read from fsys, or from the file system if fsys is nil.
+func (c *Coverage) WriteHTML(w io.Writer, fsys fs.FS) error {
+	type line struct {
+		Num   int
+		Count int64
+		Class string
+		Code  string
+	}
+	type file struct {
+		Name    string
+		Percent string
+		Lines   []line
+	}
+	var files []file
+	for _, f := range c.files() {
+		src, err := c.source(f.file, fsys)
+		if err != nil {
+			return err
+		}
+		var hit int
+		lines := strings.Split(strings.TrimSuffix(src, "\n"), "\n")
+		hf := file{Name: f.file, Lines: make([]line, len(lines))}
+		for i, code := range lines {
+			l := line{Num: i + 1, Code: code}
+			if n, ok := f.counts[l.Num]; ok {
+				l.Count = n
+				switch {
+				case f.partial[l.Num]:
+					l.Class = "part"
+				case n > 0:
+					l.Class = "cov"
+				default:
+					l.Class = "uncov"
+				}
+				if n > 0 {
+					hit++
+				}
+			}
+			hf.Lines[i] = l
+		}
+		hf.Percent = fmt.Sprintf("%.1f%%", 100*float64(hit)/float64(len(f.lines)))
+		files = append(files, hf)
+	}
+	return htmlCoverage.Execute(w, files)
+}
+
+func (c *Coverage) source(name string, fsys fs.FS) (string, error) {
+	c.mu.Lock()
+	src, ok := c.sources[name]
+	c.mu.Unlock()
+	if ok {
+		return src, nil
+	}
+	var bs []byte
+	var err error
+	if fsys != nil {
+		bs, err = fs.ReadFile(fsys, name)
+	} else {
+		bs, err = os.ReadFile(name)
+	}
+	return string(bs), err
+}
+
+var htmlCoverage = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Coverage</title>
+<style>
+body { font-family: monospace; background: #fff; color: #333; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td { padding: 0 0.5em; white-space: pre; }
+td.num, td.count { color: #999; text-align: right; }
+tr.cov td.code { background: #cfc; }
+tr.part td.code { background: #ffc; }
+tr.uncov td.code { background: #fcc; }
+</style>
+</head>
+<body>
+{{range .}}<h2>{{.Name}} ({{.Percent}})</h2>
+<table>
+{{range .Lines}}<tr class="{{.Class}}"><td class="num">{{.Num}}</td><td class="count">{{if .Class}}{{.Count}}{{end}}</td><td class="code">{{.Code}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
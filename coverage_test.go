@@ -0,0 +1,88 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/goulash/twikutil"
+	"gopkg.in/twik.v1"
+)
+
+func TestCoverage(z *testing.T) {
+	code := `(func sign (x)
+  (if (< x 0)
+    "-"
+    "+"))
+(var s (sign 1))
+(func unused () (sign 0))
+`
+	e := twikutil.New(func(*twik.Scope) twikutil.FuncMap {
+		return twikutil.FuncMap{"<": func(a, b int64) bool { return a < b }}
+	})
+	c := e.StartCoverage()
+	if _, err := e.ExecString("sign.twik", code); err != nil {
+		z.Fatal(err)
+	}
+	c.Stop()
+
+	var buf bytes.Buffer
+	if err := c.WriteGoCover(&buf); err != nil {
+		z.Fatal(err)
+	}
+	want := `mode: count
+sign.twik:1.1,4.10 1 1
+sign.twik:2.3,4.9 1 1
+sign.twik:2.7,2.14 1 1
+sign.twik:3.5,3.8 1 0
+sign.twik:4.5,4.8 1 1
+sign.twik:5.1,5.17 1 1
+sign.twik:5.8,5.16 1 1
+sign.twik:6.1,6.26 1 1
+sign.twik:6.17,6.25 1 0
+`
+	if buf.String() != want {
+		z.Errorf("go cover:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	// Merging a run with itself doubles the counts.
+	r, err := twikutil.ReadCoverage(strings.NewReader(want))
+	if err != nil {
+		z.Fatal(err)
+	}
+	r.Merge(c)
+	bs := r.Blocks()
+	if len(bs) != 9 || bs[0].Count != 2 || bs[3].Count != 0 || bs[8].Count != 0 {
+		z.Errorf("merged blocks: %v", bs)
+	}
+	if p := r.Percent(); int(p) != 77 {
+		z.Errorf("percent = %v", p)
+	}
+
+	buf.Reset()
+	if err := c.WriteLcov(&buf); err != nil {
+		z.Fatal(err)
+	}
+	want = "TN:\nSF:sign.twik\nDA:1,1\nDA:2,1\nDA:3,0\nDA:4,1\nDA:5,1\nDA:6,0\nLF:6\nLH:4\nend_of_record\n"
+	if buf.String() != want {
+		z.Errorf("lcov:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := c.WriteHTML(&buf, nil); err != nil {
+		z.Fatal(err)
+	}
+	for _, s := range []string{
+		"<h2>sign.twik (66.7%)</h2>",
+		`<tr class="part"><td class="num">6</td><td class="count">0</td>`,
+		`<tr class="uncov"><td class="num">3</td><td class="count">0</td><td class="code">    &#34;-&#34;</td>`,
+	} {
+		if !strings.Contains(buf.String(), s) {
+			z.Errorf("HTML does not contain %s:\n%s", s, buf.String())
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"gopkg.in/twik.v1"
+	"gopkg.in/twik.v1/ast"
+)
+
+// StepMode tells a Debugger how to continue after it paused.
+type StepMode int
+
+const (
+	Continue StepMode = iota // run until the next breakpoint
+	StepIn                   // pause at the next form
+	StepOver                 // pause at the next form that is not nested in this one
+	StepOut                  // pause at the next form after the one this one is nested in
+	Abort                    // stop running the script with ErrAborted
+)
+
+// ErrAborted is the error of a script that a Debugger aborted.
+var ErrAborted = errors.New("aborted by debugger")
+
+// Debugger pauses an Executer at breakpoints and while stepping through
+// a script. While paused, the current forms can be inspected, and code can
+// be evaluated in their scope.
+//
+// A Debugger is started with StartDebugger and is active until it is
+// stopped. It must not be used by Executers that run at the same time.
+type Debugger struct {
+	e     *Executer
+	pause func(*Frame) StepMode
+
+	mu     sync.Mutex
+	bps    map[Position]bool
+	mode   StepMode
+	depth  int
+	stack  []*Frame
+	paused bool
+}
+
+// Frame is a form that is being evaluated.
+type Frame struct {
+	Function string   // name of the function the form calls, or ""
+	Pos      Position // position of the form
+	Depth    int      // number of forms the form is nested in
+
+	e     *Executer
+	scope *twik.Scope
+	form  *form
+}
+
+// StartDebugger starts debugging e. Whenever e pauses, pause is called
+// with the form that is about to be evaluated, and returns how to continue.
+// The script is not run while pause runs.
+//
+// Initially, e only pauses at breakpoints. Use Step to pause at the first
+// form instead.
+func (e *Executer) StartDebugger(pause func(f *Frame) StepMode) *Debugger {
+	d := &Debugger{
+		e:     e,
+		pause: pause,
+		bps:   make(map[Position]bool),
+	}
+	e.addProbe(d, d.probe)
+	return d
+}
+
+// Stop stops debugging.
+func (d *Debugger) Stop() {
+	d.e.removeProbe(d)
+}
+
+// Step sets how to continue as if pause had returned m at the outermost
+// form. For example, StepIn pauses at the next form that is evaluated.
+func (d *Debugger) Step(m StepMode) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mode = m
+	d.depth = len(d.stack)
+}
+
+// SetBreakpoint sets a breakpoint at the line of file, which is a file
+// as named in error messages. If the PreProcessor included files, the
+// breakpoint can be in an included file. The script pauses at each form
+// that starts on the line, but not at forms nested in a form that starts
+// on the same line.
+func (d *Debugger) SetBreakpoint(file string, line int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.bps[Position{File: file, Line: line}] = true
+}
+
+// ClearBreakpoint removes the breakpoint at the line of file.
+func (d *Debugger) ClearBreakpoint(file string, line int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.bps, Position{File: file, Line: line})
+}
+
+// Breakpoints returns the positions of all breakpoints, sorted.
+func (d *Debugger) Breakpoints() []Position {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ps := make([]Position, 0, len(d.bps))
+	for p := range d.bps {
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(i, j int) bool { return before(ps[i], ps[j]) })
+	return ps
+}
+
+// Stack returns the forms that are being evaluated, innermost last.
+func (d *Debugger) Stack() []*Frame {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]*Frame(nil), d.stack...)
+}
+
+func (d *Debugger) probe(f *form, s *twik.Scope, eval func() (interface{}, error)) (interface{}, error) {
+	d.mu.Lock()
+	if d.paused {
+		// Code evaluated while paused is not debugged.
+		d.mu.Unlock()
+		return eval()
+	}
+	fr := &Frame{Function: f.name, Pos: f.pos, Depth: len(d.stack), e: d.e, scope: s, form: f}
+	pause := d.shouldPause(fr)
+	d.stack = append(d.stack, fr)
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.stack = d.stack[:len(d.stack)-1]
+		d.mu.Unlock()
+	}()
+
+	if pause {
+		d.mu.Lock()
+		d.paused = true
+		d.mu.Unlock()
+		m := d.pause(fr)
+		d.mu.Lock()
+		d.paused = false
+		d.mode = m
+		d.depth = fr.Depth
+		if m == Abort {
+			d.mode = Continue
+		}
+		d.mu.Unlock()
+		if m == Abort {
+			return nil, ErrAborted
+		}
+	}
+	return eval()
+}
+
+// shouldPause reports whether to pause at fr, which is not on the stack yet.
+func (d *Debugger) shouldPause(fr *Frame) bool {
+	switch d.mode {
+	case StepIn:
+		return true
+	case StepOver:
+		if fr.Depth <= d.depth {
+			return true
+		}
+	case StepOut:
+		if fr.Depth < d.depth {
+			return true
+		}
+	}
+	line := Position{File: fr.Pos.File, Line: fr.Pos.Line}
+	if !d.bps[line] {
+		return false
+	}
+	if n := len(d.stack); n > 0 {
+		p := d.stack[n-1].Pos
+		return p.File != line.File || p.Line != line.Line
+	}
+	return true
+}
+
+// Code returns the code of the form.
+func (f *Frame) Code() string {
+	return f.form.code
+}
+
+// Variables returns the variables that are visible in the scope of the
+// form and are used in its program, except for functions.
+func (f *Frame) Variables() map[string]interface{} {
+	vars := make(map[string]interface{})
+	for _, name := range f.form.prog.symbols() {
+		switch name {
+		case "true", "false", "nil":
+			continue
+		}
+		v, err := f.scope.Get(name)
+		if err != nil {
+			continue
+		}
+		switch v.(type) {
+		case func(*twik.Scope, []ast.Node) (interface{}, error), func([]interface{}) (interface{}, error), Keyword:
+			continue
+		}
+		vars[name] = v
+	}
+	return vars
+}
+
+// Eval evaluates code in the scope of the form and returns the value of
+// the last expression. Errors refer to code by the name "eval".
+func (f *Frame) Eval(code string) (interface{}, error) {
+	node, err := f.e.fset.parseString("eval", code, nil)
+	if err != nil {
+		return nil, err
+	}
+	v, err := f.scope.Eval(node)
+	return v, f.e.fset.error(err)
+}
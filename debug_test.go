@@ -0,0 +1,122 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/goulash/twikutil"
+)
+
+const debugCode = `(func add (a b)
+  (+ a b))
+(var x 1)
+(var y (add x 2))
+(set x (add y 3))
+`
+
+func TestDebuggerBreakpoint(z *testing.T) {
+	e := twikutil.New(noFuncs)
+	var vars []map[string]interface{}
+	d := e.StartDebugger(func(f *twikutil.Frame) twikutil.StepMode {
+		if f.Function != "+" || f.Pos.String() != "test:2:3" || f.Code() != "(+ a b)" {
+			z.Errorf("paused at %s %s: %s", f.Function, f.Pos, f.Code())
+		}
+		vars = append(vars, f.Variables())
+		if v, err := f.Eval("(* a b)"); err != nil || v != vars[len(vars)-1]["a"].(int64)*vars[len(vars)-1]["b"].(int64) {
+			z.Errorf("eval = %v, %v", v, err)
+		}
+		return twikutil.Continue
+	})
+	d.SetBreakpoint("test", 2)
+	d.SetBreakpoint("test", 9)
+	d.ClearBreakpoint("test", 9)
+	if bps := d.Breakpoints(); len(bps) != 1 || bps[0].Line != 2 {
+		z.Errorf("breakpoints = %v", bps)
+	}
+	if _, err := e.ExecString("test", debugCode); err != nil {
+		z.Fatal(err)
+	}
+	want := []map[string]interface{}{
+		{"a": int64(1), "b": int64(2), "x": int64(1)},
+		{"a": int64(3), "b": int64(3), "x": int64(1), "y": int64(3)},
+	}
+	if !reflect.DeepEqual(vars, want) {
+		z.Errorf("variables = %v; want %v", vars, want)
+	}
+	d.Stop()
+}
+
+func TestDebuggerStep(z *testing.T) {
+	tests := []struct {
+		Modes []twikutil.StepMode
+		Pause []string
+	}{
+		{nil, []string{"func"}},
+		{[]twikutil.StepMode{twikutil.StepOver, twikutil.StepOver, twikutil.StepOver}, []string{"func", "var", "var", "set"}},
+		{[]twikutil.StepMode{twikutil.StepOver, twikutil.StepOver, twikutil.StepIn, twikutil.StepIn, twikutil.StepOut}, []string{"func", "var", "var", "add", "+", "set"}},
+		{[]twikutil.StepMode{twikutil.StepOver, twikutil.StepOver, twikutil.StepIn, twikutil.StepOver, twikutil.StepOver}, []string{"func", "var", "var", "add", "set"}},
+	}
+	for _, t := range tests {
+		e := twikutil.New(noFuncs)
+		var paused []string
+		d := e.StartDebugger(func(f *twikutil.Frame) twikutil.StepMode {
+			paused = append(paused, f.Function)
+			if len(paused) > len(t.Modes) {
+				return twikutil.Continue
+			}
+			return t.Modes[len(paused)-1]
+		})
+		d.Step(twikutil.StepIn)
+		if _, err := e.ExecString("test", debugCode); err != nil {
+			z.Fatal(err)
+		}
+		if !reflect.DeepEqual(paused, t.Pause) {
+			z.Errorf("%v: paused at %v; want %v", t.Modes, paused, t.Pause)
+		}
+	}
+}
+
+func TestDebuggerAbort(z *testing.T) {
+	e := twikutil.New(noFuncs)
+	var stack []string
+	var d *twikutil.Debugger
+	d = e.StartDebugger(func(f *twikutil.Frame) twikutil.StepMode {
+		for _, fr := range d.Stack() {
+			stack = append(stack, fr.Function)
+		}
+		return twikutil.Abort
+	})
+	d.SetBreakpoint("test", 2)
+	_, err := e.ExecString("test", debugCode)
+	if err == nil || !strings.Contains(err.Error(), twikutil.ErrAborted.Error()) {
+		z.Errorf("unexpected error: %v", err)
+	}
+	if v, _ := e.Get("y"); v != nil {
+		z.Errorf("y = %v", v)
+	}
+	if want := []string{"var", "add", "+"}; !reflect.DeepEqual(stack, want) {
+		z.Errorf("stack = %v; want %v", stack, want)
+	}
+}
+
+func TestDebuggerEvalError(z *testing.T) {
+	e := twikutil.New(noFuncs)
+	d := e.StartDebugger(func(f *twikutil.Frame) twikutil.StepMode {
+		if _, err := f.Eval("\n (undefined)"); err == nil || !strings.HasPrefix(err.Error(), "eval:2:3:") {
+			z.Errorf("unexpected eval error: %v", err)
+		}
+		return twikutil.Continue
+	})
+	defer d.Stop()
+	d.SetBreakpoint("test", 1)
+	// The script fails after code was evaluated in it.
+	_, err := e.ExecString("test", "(var x 1)\n(undefined x)")
+	if err == nil || !strings.HasPrefix(err.Error(), "test:2:2:") {
+		z.Errorf("unexpected error: %v", err)
+	}
+}
@@ -6,7 +6,9 @@ package twikutil
 
 import (
 	"errors"
+	"io"
 	"io/ioutil"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -14,7 +16,6 @@ import (
 	past "github.com/goulash/pre/ast"
 
 	"gopkg.in/twik.v1"
-	"gopkg.in/twik.v1/ast"
 )
 
 var ErrFuncExists = errors.New("cannot set variable with name of existing function")
@@ -24,51 +25,131 @@ type LoaderFunc func(*twik.Scope) FuncMap
 type Executer struct {
 	PreProcessor *pre.Processor
 
-	fset  *ast.FileSet
+	fset  *fileSet
 	scope *twik.Scope
-	funcs map[string]bool
+	funcs map[string]interface{} // exported functions
+	cache *programCache
+
+	modpath []modulePath
+	modules map[string][]binding // imported modules
+	loading []string
+
+	hooks  *Hooks
+	probes []probeEntry
+	instr  *instrumentation
+	sys    System
+	stdout io.Writer
+	stderr io.Writer
 }
 
 func New(loader LoaderFunc) *Executer {
-	fset := twik.NewFileSet()
-	s := twik.NewScope(fset)
+	fset := newFileSet()
+	s := newScope(fset.eval)
 	fns := loader(s)
-	keys := make(map[string]bool)
-	for k := range fns {
-		keys[k] = true
+	keys := make(map[string]interface{})
+	for k, v := range fns {
+		keys[k] = v
 	}
 	fns.Export(s)
-	return &Executer{
-		fset:  fset,
-		scope: s,
-		funcs: keys,
+	e := &Executer{
+		fset:    fset,
+		scope:   s,
+		funcs:   keys,
+		cache:   newProgramCache(),
+		modules: make(map[string][]binding),
+		instr:   newInstrumentation(),
+	}
+	for k, v := range e.builtins() {
+		if s.Create(k, v) == nil {
+			keys[k] = nil
+		}
+	}
+	return e
+}
+
+// builtins returns the functions that every scope of e has besides those
+// of twik and the exported functions.
+func (e *Executer) builtins() map[string]interface{} {
+	return map[string]interface{}{
+		"import":        e.importFn,
+		"try":           e.tryFn,
+		"raise":         Func("raise", raise),
+		"error-message": Func("error-message", errorMessage),
 	}
 }
 
 func (e *Executer) Scope() *twik.Scope { return e.scope }
 
+func (e *Executer) isFunc(key string) bool {
+	_, ok := e.funcs[key]
+	return ok
+}
+
+// Fork returns a new Executer whose scope is a branch of the scope of e.
+// Everything defined in e is visible in the fork, but variables that are
+// set with Set or created by scripts in the fork do not affect e.
+// Note that (set x ...) in a script still modifies a variable x of e,
+// unless x has been shadowed in the fork with Set.
+//
+// Modules imported by the fork are only visible in the fork.
+// The fork shares the file set and program cache of e, so programs
+// compiled by either can be run in both without being parsed again.
+func (e *Executer) Fork() *Executer {
+	funcs := make(map[string]interface{}, len(e.funcs))
+	for k, v := range e.funcs {
+		funcs[k] = v
+	}
+	modules := make(map[string][]binding, len(e.modules))
+	for k, v := range e.modules {
+		modules[k] = v
+	}
+	f := &Executer{
+		PreProcessor: e.PreProcessor,
+		fset:         e.fset,
+		scope:        e.scope.Branch(),
+		funcs:        funcs,
+		cache:        e.cache,
+		modpath:      append([]modulePath(nil), e.modpath...),
+		modules:      modules,
+		hooks:        e.hooks,
+		probes:       append([]probeEntry(nil), e.probes...),
+		instr:        e.instr,
+		sys:          e.sys,
+		stdout:       e.stdout,
+		stderr:       e.stderr,
+	}
+	// The builtins of e import into e, so the fork needs its own.
+	f.define("import", f.importFn)
+	f.define("try", f.tryFn)
+	return f
+}
+
 // It is an error to use a key that has already been used as a function.
 func (e *Executer) Set(key string, value interface{}) error {
-	if e.funcs[key] {
+	if e.isFunc(key) {
 		return errors.New("function with that name already exists")
 	}
-	_, err := e.scope.Get(key)
-	if err == nil {
-		return e.scope.Set(key, value)
+	// Creating the key first lets a fork shadow variables of its parent.
+	err := e.scope.Create(key, value)
+	if err != nil {
+		err = e.scope.Set(key, value)
+	}
+	if err == nil && e.hooks != nil && e.hooks.OnSet != nil {
+		e.hooks.OnSet(key, value)
 	}
-	return e.scope.Create(key, value)
+	return err
 }
 
 // It is an error to get a key that has already been used as a function.
 func (e *Executer) Get(key string) (interface{}, error) {
-	if e.funcs[key] {
+	if e.isFunc(key) {
 		return nil, errors.New("functions cannot be gotten")
 	}
 	return e.scope.Get(key)
 }
 
 func (e *Executer) Has(key string) bool {
-	if e.funcs[key] {
+	if e.isFunc(key) {
 		return false
 	}
 	v, err := e.scope.Get(key)
@@ -76,19 +157,49 @@ func (e *Executer) Has(key string) bool {
 }
 
 func (e *Executer) Create(key string, fn interface{}) error {
-	err := e.scope.Create(key, Func(key, fn))
+	err := e.scope.Create(key, e.adapter(key, fn))
 	if err != nil {
 		return err
 	}
-	e.funcs[key] = true
+	e.funcs[key] = fn
 	return nil
 }
 
+// Export exports all functions in fm, so that they are known to e just like
+// those returned by the loader. If any of the names is already defined,
+// nothing is exported and a ConflictError is returned.
+func (e *Executer) Export(fm FuncMap) error {
+	var names []string
+	for k := range fm {
+		if _, err := e.scope.Get(k); err == nil || e.isFunc(k) {
+			names = append(names, k)
+		}
+	}
+	if len(names) != 0 {
+		sort.Strings(names)
+		return &ConflictError{names}
+	}
+	for k, v := range fm {
+		if v != nil {
+			e.scope.Create(k, e.adapter(k, v))
+			e.funcs[k] = v
+		}
+	}
+	return nil
+}
+
+// ExportPrefixed exports all functions in fm with their names prefixed
+// with prefix, as with Export.
+func (e *Executer) ExportPrefixed(prefix string, fm FuncMap) error {
+	return e.Export(fm.Prefixed(prefix))
+}
+
 func (e *Executer) Override(key string, fn interface{}) error {
-	if !e.funcs[key] {
+	if !e.isFunc(key) {
 		return errors.New("no function by that name exists")
 	}
-	return e.scope.Set(key, Func(key, fn))
+	e.funcs[key] = fn
+	return e.scope.Set(key, e.adapter(key, fn))
 }
 
 func (e *Executer) Exec(file string) (s *twik.Scope, err error) {
@@ -99,36 +210,43 @@ func (e *Executer) Exec(file string) (s *twik.Scope, err error) {
 	return e.ExecString(file, string(bs))
 }
 
-func (e *Executer) ExecString(name, code string) (s *twik.Scope, err error) {
-	// When the preprocessor is active, we need to convert any error messages
-	// we get from twik so that they correspond to the correct file name, line
-	// and column. This we do in the deferred function.
-	var root past.Node
-	if e.PreProcessor != nil {
-		root, err = e.PreProcessor.ParseString(name, code)
-		code = root.String()
-		if err != nil {
-			return nil, err
-		}
+func (e *Executer) ExecString(name, code string) (*twik.Scope, error) {
+	code, root, err := e.preprocess(name, code)
+	if err != nil {
+		return nil, err
+	}
+	return e.exec(name, code, root)
+}
 
-		defer func() {
-			if err != nil {
-				err = replaceError(name, root, err)
-			}
-		}()
+// preprocess runs code through the PreProcessor, if there is one, and
+// returns the resulting code along with the root for position mapping.
+func (e *Executer) preprocess(name, code string) (string, past.Node, error) {
+	if e.PreProcessor == nil {
+		return code, nil, nil
 	}
+	root, err := e.PreProcessor.ParseString(name, code)
+	if err != nil {
+		return "", nil, err
+	}
+	return root.String(), root, nil
+}
 
-	node, err := twik.ParseString(e.fset, name, code)
+func (e *Executer) exec(name, code string, root past.Node) (*twik.Scope, error) {
+	p, err := e.compile(name, code, root)
 	if err != nil {
 		return nil, err
 	}
-	_, err = e.scope.Eval(node)
-	return e.scope, err
+	return e.Run(p)
 }
 
 func replaceError(name string, root past.Node, err error) error {
 	if e, ok := err.(*twik.Error); ok {
 		epi := e.PosInfo
+		if epi.Name != name {
+			// The error occurred in a different file, such as an
+			// imported module, and has already been converted.
+			return e
+		}
 		pi := root.OffsetLC(epi.Line, epi.Column)
 		if pi != nil {
 			epi.Name = pi.Name
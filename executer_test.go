@@ -0,0 +1,116 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil_test
+
+import (
+	"math"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/goulash/twikutil"
+	"gopkg.in/twik.v1"
+)
+
+func TestExecuterExportPrefixed(z *testing.T) {
+	e := twikutil.New(noFuncs)
+	fm := twikutil.FuncMap{"upper": strings.ToUpper}
+	if err := e.ExportPrefixed("str.", fm); err != nil {
+		z.Fatal(err)
+	}
+	if err := e.ExportPrefixed("str.", fm); err == nil {
+		z.Error("exporting the same names twice did not fail")
+	}
+	if err := e.Set("str.upper", "x"); err == nil {
+		z.Error("Set clobbered a prefixed function")
+	}
+	if _, err := e.ExecString("test", `(var x (str.upper "a"))`); err != nil {
+		z.Fatal(err)
+	}
+	if v, _ := e.Get("x"); v != "A" {
+		z.Errorf("x = %v; want A", v)
+	}
+}
+
+func TestExecuterGlobals(z *testing.T) {
+	e := twikutil.New(func(*twik.Scope) twikutil.FuncMap {
+		return twikutil.FuncMap{"list": func(xs ...interface{}) []interface{} { return xs }}
+	})
+	tests := []struct {
+		Code  string
+		Value interface{}
+	}{
+		{`(== (list 1 "a") (list 1 "a"))`, true},
+		{`(!= (list 1) (list 2))`, true},
+		{`(== list list)`, false},
+		{`(== 1 1.0)`, false},
+		{`(== 1 1)`, true},
+		{`(== nil nil)`, true},
+		{`(/ 6 2)`, int64(3)},
+		{`(/ 7 2 2)`, int64(1)},
+		{`(/ 6.0 4)`, 1.5},
+		{`(/ 6 4.0)`, 1.5},
+		{`(/ 6.0 0)`, math.Inf(1)},
+		{`(/ -6 0.0)`, math.Inf(-1)},
+	}
+	for _, t := range tests {
+		e.Set("result", nil)
+		if _, err := e.ExecString("test", "(set result "+t.Code+")"); err != nil {
+			z.Errorf("%s: unexpected error: %v", t.Code, err)
+			continue
+		}
+		if v, _ := e.Get("result"); v != t.Value {
+			z.Errorf("%s = %v; want %v", t.Code, v, t.Value)
+		}
+	}
+	for _, code := range []string{`(/ 1 0)`, `(/ 1 2 0)`} {
+		if _, err := e.ExecString("test", code); err == nil || !strings.Contains(err.Error(), "integer division by zero") {
+			z.Errorf("%s: unexpected error: %v", code, err)
+		}
+	}
+	for _, code := range []string{`(/ 1)`, `(/ 1 "a")`, `(/ 1 0 "a")`} {
+		if _, err := e.ExecString("test", code); err == nil {
+			z.Errorf("%s: expected an error", code)
+		}
+	}
+
+	// Modules see the same functions.
+	e.AddModuleFS(fstest.MapFS{"m.twik": {Data: []byte(`(var v (== (list 1) (list 1)))`)}})
+	if _, err := e.ExecString("test", `(import "m") (set result m/v)`); err != nil {
+		z.Fatal(err)
+	}
+	if v, _ := e.Get("result"); v != true {
+		z.Errorf("m/v = %v; want true", v)
+	}
+}
+
+func TestExecStringMemory(z *testing.T) {
+	e := twikutil.New(noFuncs)
+	e.Set("x", int64(0))
+	code := "(set x 0)\n" + strings.Repeat("(set x (+ x 1))\n", 64)
+	heap := func() uint64 {
+		var ms runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&ms)
+		return ms.HeapAlloc
+	}
+	run := func(n int) {
+		for i := 0; i < n; i++ {
+			if _, err := e.ExecString("main", code); err != nil {
+				z.Fatal(err)
+			}
+		}
+	}
+	run(10)
+	before := heap()
+	run(5000)
+	if after := heap(); after > before+1<<20 {
+		z.Errorf("heap grew from %d to %d bytes running the same script", before, after)
+	}
+	if v, _ := e.Get("x"); v != int64(64) {
+		z.Errorf("x = %v; want 64", v)
+	}
+}
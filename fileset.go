@@ -0,0 +1,166 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil
+
+import (
+	"crypto/sha256"
+	"sort"
+	"strings"
+	"sync"
+
+	past "github.com/goulash/pre/ast"
+
+	"gopkg.in/twik.v1"
+	"gopkg.in/twik.v1/ast"
+)
+
+// fileSet contains the code parsed by an Executer and its forks, so that
+// positions in errors can be traced back to the files they came from.
+//
+// The scopes of an Executer do not look positions up in the file set that
+// the code was parsed into, because ast.FileSet.PosInfo panics for any
+// position that is not in the file parsed last. An error in a function
+// defined by an earlier script, in an imported module, or in a Program
+// compiled before another would otherwise crash the host. Instead, the
+// scopes use a file set with a single file of blanks that covers every
+// position, so that twik reports the position itself as the column, and
+// error maps it back to the file it belongs to.
+//
+// Neither file set can forget code, because functions defined by it may
+// still be called. Code that is parsed again under the same name is not
+// added once more, so that running the same script repeatedly does not
+// make the file sets grow.
+type fileSet struct {
+	mu    sync.Mutex
+	parse *ast.FileSet // the code is parsed into this one
+	eval  *ast.FileSet // the scopes look positions up in this one
+	size  int          // number of positions covered by eval
+	files []parsedFile // in the order of their base
+	index map[[sha256.Size]byte]int
+}
+
+type parsedFile struct {
+	base ast.Pos
+	name string
+	code string
+	root past.Node // nil if not preprocessed
+	node ast.Node
+}
+
+// evalName is the name of the file in the eval file set. It cannot be
+// the name of a real file.
+const evalName = "\x00twik"
+
+func newFileSet() *fileSet {
+	fs := &fileSet{
+		parse: twik.NewFileSet(),
+		eval:  twik.NewFileSet(),
+		index: make(map[[sha256.Size]byte]int),
+	}
+	fs.grow(4096)
+	return fs
+}
+
+// grow makes the eval file set cover at least n positions. The file set
+// is replaced in place, because the scopes refer to it.
+func (fs *fileSet) grow(n int) {
+	if n <= fs.size {
+		return
+	}
+	if n < 2*fs.size {
+		n = 2 * fs.size
+	}
+	eval := twik.NewFileSet()
+	twik.ParseString(eval, evalName, strings.Repeat(" ", n))
+	*fs.eval = *eval
+	fs.size = n
+}
+
+// parseString parses code under name, which has been preprocessed into
+// root if root is not nil.
+//
+// If the same code has been parsed under name before, the node parsed then
+// is returned. Its positions are mapped with root from now on, since the
+// included files may have moved.
+func (fs *fileSet) parseString(name, code string, root past.Node) (ast.Node, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	sum := sha256.Sum256([]byte(name + "\x00" + code))
+	if i, ok := fs.index[sum]; ok {
+		fs.files[i].root = root
+		return fs.files[i].node, nil
+	}
+	node, err := twik.ParseString(fs.parse, name, code)
+	if err != nil {
+		return nil, err
+	}
+	fs.index[sum] = len(fs.files)
+	fs.files = append(fs.files, parsedFile{node.Pos(), name, code, root, node})
+	fs.grow(int(node.End()) + 1)
+	return node, nil
+}
+
+// posInfo returns the position in the original file of pi, which was
+// reported by twik for a node parsed into fs. If pi was not, it returns
+// nil.
+func (fs *fileSet) posInfo(pi *ast.PosInfo) *ast.PosInfo {
+	if pi == nil || pi.Name != evalName || pi.Line != 1 {
+		return nil
+	}
+	pos := ast.Pos(pi.Column)
+	fs.mu.Lock()
+	i := sort.Search(len(fs.files), func(i int) bool { return fs.files[i].base > pos }) - 1
+	var f parsedFile
+	if i >= 0 {
+		f = fs.files[i]
+	}
+	fs.mu.Unlock()
+	if i < 0 || int(pos-f.base) > len(f.code) {
+		return nil
+	}
+
+	// This is how ast.FileSet.PosInfo computes the line and column.
+	code := f.code[:pos-f.base]
+	r := &ast.PosInfo{Name: f.name, Line: 1 + strings.Count(code, "\n")}
+	if i := strings.LastIndex(code, "\n"); i >= 0 {
+		r.Column = len(code) - i
+	} else {
+		r.Column = 1 + len(code)
+	}
+	if f.root != nil {
+		if p := f.root.OffsetLC(r.Line, r.Column); p != nil {
+			r.Name, r.Line, r.Column = p.Name, p.Line, p.Column
+		}
+	}
+	return r
+}
+
+// error converts err, if it is a *twik.Error for a node parsed into fs,
+// so that it refers to the original file name, line, and column.
+func (fs *fileSet) error(err error) error {
+	if te, ok := err.(*twik.Error); ok {
+		if pi := fs.posInfo(te.PosInfo); pi != nil {
+			*te.PosInfo = *pi
+		}
+	}
+	return err
+}
+
+// callbacks returns f with the twik functions among its arguments wrapped
+// so that their errors are converted. Go functions may keep them and call
+// them after the script has finished.
+func (fs *fileSet) callbacks(f func([]interface{}) (interface{}, error)) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		for i, a := range args {
+			if fn, ok := a.(func([]interface{}) (interface{}, error)); ok {
+				args[i] = func(args []interface{}) (interface{}, error) {
+					v, err := fn(args)
+					return v, fs.error(err)
+				}
+			}
+		}
+		return f(args)
+	}
+}
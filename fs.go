@@ -0,0 +1,262 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/goulash/pre"
+	past "github.com/goulash/pre/ast"
+
+	"gopkg.in/twik.v1"
+)
+
+// ExecFS executes the file at path in fsys. When the PreProcessor is set,
+// includes are resolved relative to path in fsys as well, and errors refer
+// to the paths within fsys. Include and require commands must stand alone
+// on their line; files are never included from outside of fsys.
+func (e *Executer) ExecFS(fsys fs.FS, path string) (*twik.Scope, error) {
+	bs, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	if e.PreProcessor == nil {
+		return e.ExecString(path, string(bs))
+	}
+	root, err := preprocessFS(e.PreProcessor, fsys, path, string(bs))
+	if err != nil {
+		return nil, err
+	}
+	return e.exec(path, root.String(), root)
+}
+
+// ExecReader executes the code read from r under the given name.
+// Includes are resolved as with ExecString.
+func (e *Executer) ExecReader(name string, r io.Reader) (*twik.Scope, error) {
+	bs, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return e.ExecString(name, string(bs))
+}
+
+// preprocessFS preprocesses code like p.ParseString would, except that
+// include and require commands read their files from fsys.
+//
+// The pre package can only read included files from the operating system,
+// so we split the code at include commands ourselves, let p process the
+// text between them, and stitch the results together in an fsNode, which
+// maps positions back to the original files just like a past.FileNode.
+//
+// The text between include commands is processed with includes disabled,
+// so that a command that we do not recognise, such as one following
+// a comment on the same line, is an error instead of being read from the
+// operating system.
+func preprocessFS(p *pre.Processor, fsys fs.FS, name, code string) (past.Node, error) {
+	text := *p
+	text.MaxIncludeDepth = 0
+	fp := &fsPreprocessor{p: p, text: &text, fsys: fsys, seen: make(map[string]bool)}
+	return fp.file(name, code, 0)
+}
+
+// errInclude is returned for include commands that preprocessFS cannot
+// resolve in the file system.
+var errInclude = errors.New("include and require must stand alone on their line")
+
+type fsPreprocessor struct {
+	p    *pre.Processor
+	text *pre.Processor // p without includes
+	fsys fs.FS
+	seen map[string]bool
+}
+
+func (fp *fsPreprocessor) file(name, code string, depth int) (past.Node, error) {
+	if depth >= fp.p.MaxIncludeDepth {
+		return nil, past.ErrMaxDepthExceeded
+	}
+
+	lines := strings.SplitAfter(code, "\n")
+	n := &fsNode{PosInfo: past.PosInfo{Name: name, Line: 1, Column: 1}, lines: len(lines)}
+	start := 0
+	chunk := func(end int) error {
+		if start == end {
+			return nil
+		}
+		text := strings.Join(lines[start:end], "")
+		root, err := fp.text.ParseString(name, text)
+		if err != nil {
+			if pe, ok := err.(*past.Error); ok {
+				pe.PosInfo.Line += start
+				if pe.Err == past.ErrMaxDepthExceeded {
+					pe.Err = errInclude
+				}
+			}
+			return err
+		}
+		n.nodes = append(n.nodes, &shiftedNode{root, start})
+		return nil
+	}
+
+	for i, line := range lines {
+		cmd, arg, col, ok := fp.command(line)
+		if !ok {
+			continue
+		}
+		if err := chunk(i); err != nil {
+			return nil, err
+		}
+		start = i + 1
+
+		target := path.Join(path.Dir(name), arg)
+		if cmd == "require" {
+			if fp.seen[target] {
+				continue
+			}
+			fp.seen[target] = true
+		}
+		bs, err := fs.ReadFile(fp.fsys, target)
+		if err == nil {
+			var sub past.Node
+			sub, err = fp.file(target, string(bs), depth+1)
+			if err == nil {
+				n.nodes = append(n.nodes, sub)
+				continue
+			}
+			if _, ok := err.(*past.Error); ok {
+				return nil, err
+			}
+		}
+		return nil, &past.Error{Err: err, PosInfo: past.PosInfo{Name: name, Line: i + 1, Column: col}}
+	}
+	if err := chunk(len(lines)); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// command parses an include or require command in line.
+func (fp *fsPreprocessor) command(line string) (cmd, arg string, col int, ok bool) {
+	return includeCommand(fp.p.Trigger, line)
+}
+
+// includeCommand parses an include or require command with the given
+// trigger in line.
+func includeCommand(trigger, line string) (cmd, arg string, col int, ok bool) {
+	s := strings.TrimLeft(line, " \t")
+	col = len(line) - len(s) + 1
+	if !strings.HasPrefix(s, trigger) {
+		return
+	}
+	s = strings.TrimSpace(strings.TrimPrefix(s, trigger))
+	for _, c := range []string{"include", "require"} {
+		if strings.HasPrefix(s, c) {
+			cmd = c
+			s = strings.TrimSpace(strings.TrimPrefix(s, c))
+			break
+		}
+	}
+	if cmd == "" || len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return
+	}
+	return cmd, s[1 : len(s)-1], col, true
+}
+
+// fsNode is the root of a file preprocessed by preprocessFS.
+type fsNode struct {
+	past.PosInfo
+	nodes []past.Node
+	lines int // number of lines in the file
+}
+
+func (n fsNode) Type() past.NodeType { return past.FileType }
+
+func (n fsNode) String() string {
+	var buf bytes.Buffer
+	for _, x := range n.nodes {
+		buf.WriteString(x.String())
+	}
+	return buf.String()
+}
+
+func (n fsNode) Len() int {
+	var total int
+	for _, x := range n.nodes {
+		total += x.Len()
+	}
+	return total
+}
+
+func (n fsNode) Offset(offset int) *past.PosInfo {
+	for _, x := range n.nodes {
+		if pi := x.Offset(offset); pi != nil {
+			return pi
+		}
+		offset -= x.Len()
+	}
+	return nil
+}
+
+func (n fsNode) OffsetLC(line, col int) *past.PosInfo {
+	if pi := n.offsetLC(line, col); pi != nil {
+		return pi
+	}
+	// Errors about a missing closing parenthesis are reported at the end
+	// of the file, which is not part of any node.
+	if line == strings.Count(n.String(), "\n")+1 {
+		return &past.PosInfo{Name: n.Name, Line: n.lines, Column: col}
+	}
+	return nil
+}
+
+func (n fsNode) offsetLC(line, col int) *past.PosInfo {
+	for _, x := range n.nodes {
+		var pi *past.PosInfo
+		if sub, ok := x.(*fsNode); ok {
+			pi = sub.offsetLC(line, col)
+		} else {
+			pi = x.OffsetLC(line, col)
+		}
+		if pi != nil {
+			return pi
+		}
+		line -= strings.Count(x.String(), "\n")
+	}
+	return nil
+}
+
+// shiftedNode is a node that was parsed from the text starting at the line
+// after line in its file.
+type shiftedNode struct {
+	past.Node
+	line int
+}
+
+func (n *shiftedNode) shift(pi *past.PosInfo) *past.PosInfo {
+	if pi != nil {
+		pi.Line += n.line
+	}
+	return pi
+}
+
+func (n *shiftedNode) Pos() *past.PosInfo              { return n.shift(n.Node.Pos()) }
+func (n *shiftedNode) Offset(offset int) *past.PosInfo { return n.shift(n.Node.Offset(offset)) }
+func (n *shiftedNode) OffsetLC(line, col int) *past.PosInfo {
+	if pi := n.Node.OffsetLC(line, col); pi != nil {
+		return n.shift(pi)
+	}
+	// The pre package does not find positions on a last line that does
+	// not end with a newline, so we do it ourselves.
+	s := n.Node.String()
+	if strings.HasSuffix(s, "\n") || line != strings.Count(s, "\n")+1 {
+		return nil
+	}
+	return n.shift(&past.PosInfo{Name: n.Node.Pos().Name, Line: line, Column: col})
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil_test
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/goulash/pre"
+	past "github.com/goulash/pre/ast"
+	"github.com/goulash/twikutil"
+)
+
+func TestExecFS(z *testing.T) {
+	fsys := fstest.MapFS{
+		"conf/main.twik":    {Data: []byte("(var a 1)\n#require \"lib/b.twik\"\n#require \"lib/b.twik\"\n(var c (+ a b))\n")},
+		"conf/lib/b.twik":   {Data: []byte("; comment\n(var b 2)\n")},
+		"conf/bad.twik":     {Data: []byte("(var a 1)\n#include \"lib/err.twik\"\n")},
+		"conf/lib/err.twik": {Data: []byte("\n\n  (undefined)\n")},
+		"conf/last.twik":    {Data: []byte("#require \"lib/b.twik\"\n(var a 1)\n(undefined)")},
+		"block.twik":        {Data: []byte("(var a 1)\n/* os */ #include \"fs_test.go\"\n")},
+		"trailing.twik":     {Data: []byte("(var a 1)\n#include \"fs_test.go\" // os\n")},
+	}
+
+	e := twikutil.New(noFuncs)
+	e.PreProcessor = pre.New()
+	if _, err := e.ExecFS(fsys, "conf/main.twik"); err != nil {
+		z.Fatal(err)
+	}
+	if v, _ := e.Get("c"); v != int64(3) {
+		z.Errorf("c = %v; want 3", v)
+	}
+
+	e = twikutil.New(noFuncs)
+	e.PreProcessor = pre.New()
+	_, err := e.ExecFS(fsys, "conf/bad.twik")
+	if err == nil || !strings.HasPrefix(err.Error(), "conf/lib/err.twik:3:4:") {
+		z.Errorf("unexpected error: %v", err)
+	}
+
+	e = twikutil.New(noFuncs)
+	e.PreProcessor = pre.New()
+	_, err = e.ExecFS(fsys, "conf/last.twik")
+	if err == nil || !strings.HasPrefix(err.Error(), "conf/last.twik:3:2:") {
+		z.Errorf("unexpected error: %v", err)
+	}
+
+	// Includes that are not recognised must not be read from the
+	// operating system, where fs_test.go exists.
+	for _, name := range []string{"block.twik", "trailing.twik"} {
+		e = twikutil.New(noFuncs)
+		e.PreProcessor = pre.New()
+		e.PreProcessor.AddCommenter(&past.Commenter{Begin: "/*", End: "*/"}, false)
+		e.PreProcessor.AddCommenter(&past.Commenter{Begin: "//"}, false)
+		_, err = e.ExecFS(fsys, name)
+		if err == nil || !strings.HasPrefix(err.Error(), name+":2:") {
+			z.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	e = twikutil.New(noFuncs)
+	_, err = e.ExecReader("reader", strings.NewReader("(var x (+ 1 y))"))
+	if err == nil || !strings.HasPrefix(err.Error(), "reader:1:") {
+		z.Errorf("unexpected error: %v", err)
+	}
+}
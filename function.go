@@ -0,0 +1,74 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil
+
+// Function is a Go function together with documentation: the names of its
+// parameters, a description, and examples. A *Function can be used anywhere
+// a plain function can, such as in a FuncMap or with Func and Format:
+//
+//  fm := twikutil.FuncMap{
+//      "write-file": twikutil.Fn(ioutil.WriteFile).
+//          Params("path", "data", "mode").
+//          Doc("Write data to the file at path.").
+//          Example(`(write-file "a" (bytes "hello") 0644)`),
+//  }
+//
+// With parameter names, Format produces a signature such as:
+//
+//  write-file :: path:string -> data:[]byte -> mode:fs.FileMode => ()
+type Function struct {
+	fn       interface{}
+	params   []string
+	doc      string
+	examples []string
+	options  *options
+}
+
+// Fn returns a new Function for the Go function f.
+func Fn(f interface{}) *Function {
+	return &Function{fn: f}
+}
+
+// FunctionOf returns v if it is a *Function, and otherwise a Function
+// without documentation for v.
+func FunctionOf(v interface{}) *Function {
+	if fn, ok := v.(*Function); ok {
+		return fn
+	}
+	return Fn(v)
+}
+
+// Params sets the names of the parameters of the function.
+func (fn *Function) Params(names ...string) *Function {
+	fn.params = names
+	return fn
+}
+
+// Doc sets the documentation of the function.
+func (fn *Function) Doc(s string) *Function {
+	fn.doc = s
+	return fn
+}
+
+// Example adds examples of how the function is called from twik.
+func (fn *Function) Example(xs ...string) *Function {
+	fn.examples = append(fn.examples, xs...)
+	return fn
+}
+
+// Func returns the Go function.
+func (fn *Function) Func() interface{} { return fn.fn }
+
+func (fn *Function) ParamNames() []string  { return fn.params }
+func (fn *Function) Documentation() string { return fn.doc }
+func (fn *Function) Examples() []string    { return fn.examples }
+
+// fnOf returns the Go function of v, which may be wrapped in a Function.
+func fnOf(v interface{}) interface{} {
+	if fn, ok := v.(*Function); ok {
+		return fn.fn
+	}
+	return v
+}
@@ -0,0 +1,325 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package twikutil_test
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/goulash/pre"
+	"github.com/goulash/twikutil"
+	"gopkg.in/twik.v1"
+	"gopkg.in/twik.v1/ast"
+)
+
+// fuzzTypes are the parameter and result types of fuzzed functions.
+var fuzzTypes = []reflect.Type{
+	reflect.TypeOf(int64(0)),
+	reflect.TypeOf(0.0),
+	reflect.TypeOf(""),
+	reflect.TypeOf(false),
+	reflect.TypeOf([]interface{}{}),
+	reflect.TypeOf((*interface{})(nil)).Elem(),
+	reflect.TypeOf((*int64)(nil)),
+	reflect.TypeOf([]byte{}),
+	reflect.TypeOf(map[string]interface{}{}),
+	reflect.TypeOf(func(string) string { return "" }),
+	reflect.TypeOf((*error)(nil)).Elem(),
+}
+
+// fuzzValues are the arguments that fuzzed functions are called with.
+var fuzzValues = []interface{}{
+	nil,
+	int64(1),
+	2.5,
+	"a",
+	true,
+	[]interface{}{int64(1), "b"},
+	map[string]interface{}{"a": int64(1)},
+	[]byte("c"),
+	new(int64),
+	errors.New("value"),
+	twikutil.Func("twik", func(s string) string { return s }),
+}
+
+// fuzzReader reads small numbers from fuzz data, and zero once it runs out.
+type fuzzReader []byte
+
+func (r *fuzzReader) next(n int) int {
+	if len(*r) == 0 {
+		return 0
+	}
+	b := (*r)[0]
+	*r = (*r)[1:]
+	return int(b) % n
+}
+
+// fuzzFunc returns a function whose type is read from r, which returns
+// zero values and possibly an error, and whether it is variadic.
+func fuzzFunc(r *fuzzReader) (interface{}, reflect.Type) {
+	in := make([]reflect.Type, r.next(4))
+	for i := range in {
+		in[i] = fuzzTypes[r.next(len(fuzzTypes))]
+	}
+	variadic := len(in) > 0 && r.next(2) == 1
+	if variadic {
+		in[len(in)-1] = reflect.SliceOf(in[len(in)-1])
+	}
+	out := make([]reflect.Type, r.next(4))
+	for i := range out {
+		out[i] = fuzzTypes[r.next(len(fuzzTypes))]
+	}
+	fail := r.next(2) == 1
+	ft := reflect.FuncOf(in, out, variadic)
+	f := reflect.MakeFunc(ft, func([]reflect.Value) []reflect.Value {
+		vs := make([]reflect.Value, len(out))
+		for i, t := range out {
+			vs[i] = reflect.Zero(t)
+			if fail && i == len(out)-1 && t == fuzzTypes[len(fuzzTypes)-1] {
+				err := errors.New("failed")
+				vs[i] = reflect.ValueOf(&err).Elem()
+			}
+		}
+		return vs
+	})
+	return f.Interface(), ft
+}
+
+func FuzzFunc(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{1, 0, 0, 1, 0, 0, 1, 1})
+	f.Add([]byte{2, 2, 3, 1, 2, 0, 10, 1, 3, 0, 0, 5, 3})
+	f.Add([]byte{3, 4, 5, 6, 1, 3, 10, 2, 1, 1, 6, 2, 3, 4, 5})
+	f.Add([]byte{1, 9, 0, 1, 2, 1, 10, 1, 0, 1, 10})
+	f.Add([]byte{0, 0, 2, 10, 3, 2, 10, 0, 8, 8, 8})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := fuzzReader(data)
+		fn, ft := fuzzFunc(&r)
+		var v interface{} = fn
+		if r.next(2) == 1 {
+			g, _ := fuzzFunc(&r)
+			v = twikutil.Overload(fn, g)
+		} else if r.next(2) == 1 {
+			v = twikutil.Fn(fn).Params("a", "b").Doc("Fuzzed.")
+		}
+		args := make([]interface{}, r.next(6))
+		for i := range args {
+			args[i] = fuzzValues[r.next(len(fuzzValues))]
+		}
+
+		twikutil.Format("fuzz", v)
+		_, err := twikutil.Func("fuzz", v)(args)
+		if err != nil {
+			if err.Error() == "" {
+				t.Errorf("empty error for %v with %d arguments", ft, len(args))
+			}
+			return
+		}
+		if _, ok := v.(twikutil.Overloads); ok {
+			return
+		}
+		if n := ft.NumIn(); ft.IsVariadic() && len(args) < n-1 || !ft.IsVariadic() && len(args) != n {
+			t.Errorf("%v accepted %d arguments", ft, len(args))
+		}
+	})
+}
+
+func fuzzFuncs(*twik.Scope) twikutil.FuncMap {
+	return twikutil.FuncMap{
+		"upper":  strings.ToUpper,
+		"double": func(x int64) int64 { return 2 * x },
+		"sum": func(xs ...int64) (n int64) {
+			for _, x := range xs {
+				n += x
+			}
+			return n
+		},
+		"fail":  func() error { return errors.New("failed") },
+		"apply": func(f func(string) (string, error), s string) (string, error) { return f(s) },
+		"list":  func(xs ...interface{}) []interface{} { return xs },
+	}
+}
+
+// errorPos matches the position at the start of an error message.
+var errorPos = regexp.MustCompile(`^([^:]+):(\d+):(\d+):`)
+
+// checkError checks that err starts with a valid position in one of the
+// files.
+func checkError(t *testing.T, err error, files map[string]string) {
+	m := errorPos.FindStringSubmatch(err.Error())
+	if m == nil {
+		t.Fatalf("error without position: %v", err)
+	}
+	code, ok := files[m[1]]
+	if !ok {
+		t.Fatalf("error in unknown file: %v", err)
+	}
+	line, _ := strconv.Atoi(m[2])
+	col, _ := strconv.Atoi(m[3])
+	lines := strings.Split(code, "\n")
+	if line < 1 || line > len(lines) || col < 1 || col > len(lines[line-1])+2 {
+		t.Fatalf("error at invalid position: %v\ncode:\n%s", err, code)
+	}
+}
+
+// fuzzLimit is the number of forms and loop iterations a fuzzed script may
+// evaluate.
+const fuzzLimit = 1000
+
+var errLimit = errors.New("limit exceeded")
+
+// The original for and range, which bounded wraps.
+var forFn, rangeFn = func() (interface{}, interface{}) {
+	s := twik.NewScope(nil)
+	f, _ := s.Get("for")
+	r, _ := s.Get("range")
+	return f, r
+}()
+
+// bounded reports whether the script run by exec evaluates at most
+// fuzzLimit forms and loop iterations.
+func bounded(exec func(e *twikutil.Executer) error) bool {
+	e := twikutil.New(fuzzFuncs)
+	n := 0
+	d := e.StartDebugger(func(*twikutil.Frame) twikutil.StepMode {
+		if n++; n > fuzzLimit {
+			return twikutil.Abort
+		}
+		return twikutil.StepIn
+	})
+	d.Step(twikutil.StepIn)
+
+	// The debugger does not stop in loops whose bodies contain no forms,
+	// so each iteration calls tick as well.
+	e.Set("\x00tick", func([]interface{}) (interface{}, error) {
+		if n++; n > fuzzLimit {
+			return nil, errLimit
+		}
+		return nil, nil
+	})
+	loop := func(orig interface{}, body int) func(*twik.Scope, []ast.Node) (interface{}, error) {
+		fn := orig.(func(*twik.Scope, []ast.Node) (interface{}, error))
+		return func(s *twik.Scope, args []ast.Node) (interface{}, error) {
+			if len(args) > body {
+				pos := args[0].Pos()
+				tick := &ast.List{LParens: pos, RParens: pos, Nodes: []ast.Node{&ast.Symbol{Name: "\x00tick", NamePos: pos}}}
+				args = append(append(args[:body:body], tick), args[body:]...)
+			}
+			return fn(s, args)
+		}
+	}
+	e.Set("for", loop(forFn, 3))
+	e.Set("range", loop(rangeFn, 2))
+
+	exec(e)
+	return n <= fuzzLimit
+}
+
+func FuzzExecString(f *testing.F) {
+	f.Add(`(var x (upper "a"))`, false)
+	f.Add(`(func f (n) (if (== n 0) 0 (+ n (f (- n 1))))) (f 10)`, false)
+	f.Add("(double \"a\")\n(sum 1 2)", false)
+	f.Add(`(apply (func (s) (fail)) "a")`, false)
+	f.Add(`(try (raise "a") (catch e (error-message e)))`, false)
+	f.Add(`(for (var i 0) true 1 nil)`, false)
+	f.Add(`(range i 3 (double "a"))`, false)
+	f.Add(`(== (list 1) (list 1)) (/ 1 0)`, false)
+	f.Add("#include \"lib.twik\"\n(twice 2)\n(double nil)", true)
+	f.Add("#define X 1\n(var y X)\n(undefined)", true)
+	f.Add("(var s \"unclosed)\n", true)
+	f.Fuzz(func(t *testing.T, code string, preprocess bool) {
+		fsys := fstest.MapFS{
+			"main.twik": {Data: []byte(code)},
+			"lib.twik":  {Data: []byte("; lib\n(func twice (x)\n  (double x))\n")},
+		}
+		execs := map[string]func(e *twikutil.Executer) error{
+			"test": func(e *twikutil.Executer) error {
+				_, err := e.ExecString("test", code)
+				return err
+			},
+		}
+		files := map[string]string{"test": code}
+		if preprocess {
+			execs["test"] = func(e *twikutil.Executer) error {
+				e.PreProcessor = pre.New()
+				_, err := e.ExecString("test", code)
+				return err
+			}
+			execs["main.twik"] = func(e *twikutil.Executer) error {
+				e.PreProcessor = pre.New()
+				_, err := e.ExecFS(fsys, "main.twik")
+				return err
+			}
+			files["main.twik"] = code
+			files["lib.twik"] = string(fsys["lib.twik"].Data)
+		}
+		for _, exec := range execs {
+			if !bounded(exec) {
+				continue
+			}
+			if err := exec(twikutil.New(fuzzFuncs)); err != nil {
+				checkError(t, err, files)
+			}
+		}
+	})
+}
+
+// FuzzPrograms fuzzes scripts that share the file set of an Executer: two
+// programs compiled before they are run, and a script that imports the
+// second one as a module.
+func FuzzPrograms(f *testing.F) {
+	f.Add(`(undefined-a)`, `(var b 1)`)
+	f.Add(`(var a (m/f 1))`, `(func f (x) (double x))`)
+	f.Add(`(m/f "a")`, "\n(func f (x)\n  (double x))")
+	f.Add(`(var a 1)`, `(undefined-b)`)
+	f.Fuzz(func(t *testing.T, code, module string) {
+		main := "(import \"m\")\n" + code
+		files := map[string]string{"a": code, "b": module, "m.twik": module, "main": main}
+		fsys := fstest.MapFS{"m.twik": {Data: []byte(module)}}
+		run := func(e *twikutil.Executer) []error {
+			e.AddModuleFS(fsys)
+			a, errA := e.Compile("a", code)
+			b, errB := e.Compile("b", module)
+			errs := []error{errA, errB}
+			if errA == nil {
+				_, err := e.Run(a)
+				errs = append(errs, err)
+			}
+			if errB == nil {
+				_, err := e.Run(b)
+				errs = append(errs, err)
+			}
+			_, err := e.ExecString("main", main)
+			return append(errs, err)
+		}
+
+		// The module runs in a scope of its own, which bounded cannot
+		// instrument, so it must be bounded on its own.
+		alone := func(e *twikutil.Executer) error {
+			_, err := e.ExecString("m.twik", module)
+			return err
+		}
+		exec := func(e *twikutil.Executer) error {
+			run(e)
+			return nil
+		}
+		if !bounded(alone) || !bounded(exec) {
+			return
+		}
+		for _, err := range run(twikutil.New(fuzzFuncs)) {
+			if err != nil {
+				checkError(t, err, files)
+			}
+		}
+	})
+}
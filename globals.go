@@ -0,0 +1,106 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gopkg.in/twik.v1"
+	"gopkg.in/twik.v1/ast"
+)
+
+// Scripts run by an Executer, including imported modules, see the
+// following functions of twik with a different meaning, because the
+// originals crash the host for some arguments:
+//
+//  (== x y)      is true if x and y are equal; values that Go cannot
+//                compare with ==, such as lists, are compared deeply
+//  (!= x y)      is the same as (not (== x y))
+//  (/ x y ...)   is a floating-point division if any of the arguments is
+//                a float; otherwise it fails for a division by zero
+//
+// Values that twik can compare are compared as before, so (== 1 1.0)
+// is still false.
+
+// The original /, which divide wraps.
+var divFn = func() func([]interface{}) (interface{}, error) {
+	v, _ := twik.NewScope(nil).Get("/")
+	return v.(func([]interface{}) (interface{}, error))
+}()
+
+// newScope returns a new scope that looks positions up in fset, with
+// ==, !=, and / replaced as described above.
+func newScope(fset *ast.FileSet) *twik.Scope {
+	s := twik.NewScope(fset)
+	s.Set("==", equal)
+	s.Set("!=", notEqual)
+	s.Set("/", divide)
+	return s
+}
+
+func equal(args []interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errors.New("== takes two values")
+	}
+	return equalValues(args[0], args[1]), nil
+}
+
+func notEqual(args []interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errors.New("!= takes two values")
+	}
+	return !equalValues(args[0], args[1]), nil
+}
+
+func equalValues(x, y interface{}) bool {
+	if isComparable(x) && isComparable(y) {
+		return x == y
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+func isComparable(x interface{}) bool {
+	return x == nil || reflect.TypeOf(x).Comparable()
+}
+
+// divide is the / of twik, which divides the integers among its arguments
+// even when the result is a float, and panics if one of them is zero.
+func divide(args []interface{}) (interface{}, error) {
+	if len(args) < 2 {
+		return divFn(args)
+	}
+	var isFloat bool
+	for _, x := range args {
+		switch x.(type) {
+		case int64:
+		case float64:
+			isFloat = true
+		default:
+			return nil, fmt.Errorf("cannot divide with %#v", x)
+		}
+	}
+	if isFloat {
+		r := toFloat(args[0])
+		for _, x := range args[1:] {
+			r /= toFloat(x)
+		}
+		return r, nil
+	}
+	for _, x := range args[1:] {
+		if x == int64(0) {
+			return nil, errors.New("integer division by zero")
+		}
+	}
+	return divFn(args)
+}
+
+func toFloat(x interface{}) float64 {
+	if i, ok := x.(int64); ok {
+		return float64(i)
+	}
+	return x.(float64)
+}
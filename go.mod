@@ -1,6 +1,6 @@
 module github.com/goulash/twikutil
 
-go 1.14
+go 1.16
 
 require (
 	github.com/goulash/errs v1.0.0
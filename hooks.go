@@ -0,0 +1,192 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/twik.v1"
+	"gopkg.in/twik.v1/ast"
+)
+
+// Hooks are functions that an Executer calls while scripts run. Any of
+// them may be nil.
+type Hooks struct {
+	// BeforeCall is called before an exported Go function is called.
+	BeforeCall func(name string, args []interface{})
+
+	// AfterCall is called after an exported Go function returns, with
+	// its result and error, and how long the call took.
+	AfterCall func(name string, args []interface{}, result interface{}, err error, d time.Duration)
+
+	// OnSet is called after a variable has been set, either with Set or
+	// by a script with var or set.
+	OnSet func(name string, value interface{})
+
+	// CallbackError is called with the error of a callback whose Go
+	// function type has no error result, if the callback fails after the
+	// function that it was passed to has returned. If it is nil, the error
+	// is written to the standard error of the Executer.
+	CallbackError func(err error)
+}
+
+// The original var and set, which SetHooks wraps.
+var varFn, setFn = func() (interface{}, interface{}) {
+	s := twik.NewScope(nil)
+	v, _ := s.Get("var")
+	w, _ := s.Get("set")
+	return v, w
+}()
+
+// SetHooks sets the hooks of e, replacing any that were set before.
+// If h is nil, the hooks are removed. Functions are only wrapped while
+// there are hooks for them, so an Executer without hooks runs at full
+// speed.
+//
+// Forks of e inherit its hooks, but hooks set on a fork do not apply to e.
+func (e *Executer) SetHooks(h *Hooks) {
+	e.hooks = h
+	e.redefine()
+	if h != nil && h.OnSet != nil {
+		e.define("var", e.setHook(varFn))
+		e.define("set", e.setHook(setFn))
+	} else {
+		e.define("var", varFn)
+		e.define("set", setFn)
+	}
+}
+
+// redefine defines the adapters of all exported functions of e again,
+// after the hooks or implicit parameters have changed.
+func (e *Executer) redefine() {
+	for k, v := range e.funcs {
+		if v != nil {
+			e.define(k, e.adapter(k, v))
+		}
+	}
+}
+
+// define defines key in the scope of e, shadowing any definition in
+// the scope of the Executer that e was forked from.
+func (e *Executer) define(key string, value interface{}) {
+	if e.scope.Create(key, value) != nil {
+		e.scope.Set(key, value)
+	}
+}
+
+// adapter returns the adapter for fn created by Func, which passes the
+// implicit parameters of e and calls its hooks if it has any for function
+// calls.
+func (e *Executer) adapter(name string, fn interface{}) func([]interface{}) (interface{}, error) {
+	f := e.fset.callbacks(adapt(name, e.implicit().bind(fn), e.lateError()))
+	h := e.hooks
+	if h == nil || (h.BeforeCall == nil && h.AfterCall == nil) {
+		return f
+	}
+	return func(args []interface{}) (interface{}, error) {
+		if h.BeforeCall != nil {
+			h.BeforeCall(name, args)
+		}
+		start := time.Now()
+		v, err := f(args)
+		if h.AfterCall != nil {
+			h.AfterCall(name, args, v, err, time.Since(start))
+		}
+		return v, err
+	}
+}
+
+// lateError returns the handler for errors of callbacks that fail after
+// the function they were passed to has returned.
+func (e *Executer) lateError() func(error) {
+	if h := e.hooks; h != nil && h.CallbackError != nil {
+		return h.CallbackError
+	}
+	stderr := e.stderr
+	return func(err error) {
+		w := stderr
+		if w == nil {
+			w = os.Stderr
+		}
+		fmt.Fprintln(w, "twik: callback:", err)
+	}
+}
+
+func (e *Executer) setHook(orig interface{}) func(*twik.Scope, []ast.Node) (interface{}, error) {
+	fn := orig.(func(*twik.Scope, []ast.Node) (interface{}, error))
+	onSet := e.hooks.OnSet
+	return func(s *twik.Scope, args []ast.Node) (interface{}, error) {
+		v, err := fn(s, args)
+		if err != nil {
+			return v, err
+		}
+		name := args[0].(*ast.Symbol).Name
+		value, _ := s.Get(name)
+		onSet(name, value)
+		return v, nil
+	}
+}
+
+// Tracer returns hooks that write an indented log of function calls and
+// variables that are set to w:
+//
+//  (upper "abc")
+//  => "ABC" [1.2µs]
+//  set x = "ABC"
+//
+// Calls made while another call is in progress, such as callbacks, are
+// indented further. The hooks must not be used by several Executers at
+// the same time.
+func Tracer(w io.Writer) *Hooks {
+	depth := 0
+	indent := func() string { return strings.Repeat("  ", depth) }
+	return &Hooks{
+		BeforeCall: func(name string, args []interface{}) {
+			xs := make([]string, len(args)+1)
+			xs[0] = name
+			for i, a := range args {
+				xs[i+1] = repr(a)
+			}
+			fmt.Fprintf(w, "%s(%s)\n", indent(), strings.Join(xs, " "))
+			depth++
+		},
+		AfterCall: func(name string, _ []interface{}, result interface{}, err error, d time.Duration) {
+			depth--
+			if err != nil {
+				fmt.Fprintf(w, "%s!! %v [%v]\n", indent(), err, d)
+				return
+			}
+			fmt.Fprintf(w, "%s=> %s [%v]\n", indent(), repr(result), d)
+		},
+		OnSet: func(name string, value interface{}) {
+			fmt.Fprintf(w, "%sset %s = %s\n", indent(), name, repr(value))
+		},
+	}
+}
+
+// repr returns x roughly as it would be written in twik.
+func repr(x interface{}) string {
+	switch v := x.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return strconv.Quote(v)
+	case []interface{}:
+		xs := make([]string, len(v))
+		for i, y := range v {
+			xs[i] = repr(y)
+		}
+		return "(" + strings.Join(xs, " ") + ")"
+	case func([]interface{}) (interface{}, error), func(*twik.Scope, []ast.Node) (interface{}, error):
+		return "<func>"
+	default:
+		return fmt.Sprint(v)
+	}
+}
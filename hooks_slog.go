@@ -0,0 +1,36 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+//go:build go1.21
+// +build go1.21
+
+package twikutil
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// SlogHooks returns hooks that log the same events as Tracer to l, as
+// records with the messages "call", "return", and "set" at debug level.
+// Calls that fail are logged at warning level. SlogHooks requires Go 1.21.
+func SlogHooks(l *slog.Logger) *Hooks {
+	ctx := context.Background()
+	return &Hooks{
+		BeforeCall: func(name string, args []interface{}) {
+			l.Log(ctx, slog.LevelDebug, "call", "name", name, "args", args)
+		},
+		AfterCall: func(name string, args []interface{}, result interface{}, err error, d time.Duration) {
+			if err != nil {
+				l.Log(ctx, slog.LevelWarn, "return", "name", name, "err", err, "duration", d)
+				return
+			}
+			l.Log(ctx, slog.LevelDebug, "return", "name", name, "result", result, "duration", d)
+		},
+		OnSet: func(name string, value interface{}) {
+			l.Log(ctx, slog.LevelDebug, "set", "name", name, "value", value)
+		},
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+//go:build go1.21
+// +build go1.21
+
+package twikutil_test
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/goulash/twikutil"
+)
+
+func TestSlogHooks(z *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key == "time" || a.Key == "duration" {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	e := twikutil.New(hookFuncs)
+	e.SetHooks(twikutil.SlogHooks(l))
+	e.ExecString("test", `(var x (upper "a")) (fail)`)
+	want := `level=DEBUG msg=call name=upper args=[a]
+level=DEBUG msg=return name=upper result=A
+level=DEBUG msg=set name=x value=A
+level=DEBUG msg=call name=fail args=[]
+level=WARN msg=return name=fail err=failed
+`
+	if buf.String() != want {
+		z.Errorf("log:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil_test
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/goulash/twikutil"
+	"gopkg.in/twik.v1"
+)
+
+func hookFuncs(s *twik.Scope) twikutil.FuncMap {
+	return twikutil.FuncMap{
+		"upper": strings.ToUpper,
+		"apply": func(f func(string) string, s string) string { return f(s) },
+		"fail":  func() error { return errors.New("failed") },
+	}
+}
+
+func TestTracer(z *testing.T) {
+	var buf bytes.Buffer
+	e := twikutil.New(hookFuncs)
+	e.SetHooks(twikutil.Tracer(&buf))
+	e.Set("y", int64(1))
+	code := `
+		(var x (apply (func (s) (upper s)) "a"))
+		(set x (upper x))
+		(fail)
+	`
+	if _, err := e.ExecString("test", code); err == nil {
+		z.Fatal("expected error")
+	}
+	want := `set y = 1
+(apply <func> "a")
+  (upper "a")
+  => "A"
+=> "A"
+set x = "A"
+(upper "A")
+=> "A"
+set x = "A"
+(fail)
+!! failed
+`
+	got := regexp.MustCompile(` \[.*\]`).ReplaceAllString(buf.String(), "")
+	if got != want {
+		z.Errorf("trace:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Removing the hooks removes the wrappers.
+	buf.Reset()
+	e.SetHooks(nil)
+	if _, err := e.ExecString("test", `(set x (upper "b"))`); err != nil {
+		z.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		z.Errorf("unexpected trace: %s", buf.String())
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil
+
+import (
+	"io"
+	"os"
+	"reflect"
+)
+
+// Implicit parameters are leading parameters of exported Go functions that
+// are not passed by scripts, but by the Executer that runs them:
+//
+//  Sys     the System of the Executer
+//  Stdout  the standard output of the Executer
+//  Stderr  the standard error of the Executer
+//
+// For example, a function that takes a Stdout as its first parameter
+// writes to the output of the Executer:
+//
+//  "greet": func(w twikutil.Stdout, name string) { fmt.Fprintln(w, "hello", name) }
+//
+// Scripts call it as (greet "twik"). Parameters of any other type, such
+// as io.Writer or System, are passed by scripts as usual. In particular,
+// a leading io.Writer is not implicit, so that functions like fmt.Fprintf
+// can be exported as they are; use Stdout or Stderr instead.
+//
+// Func and Format ignore implicit parameters; functions adapted with Func
+// outside of an Executer are passed OS, os.Stdout, and os.Stderr as they
+// are when called.
+
+// implicitValues provides the values of implicit parameters. Those that
+// are nil are replaced by the ones of the operating system when a function
+// is called.
+type implicitValues struct {
+	sys    System
+	stdout io.Writer
+	stderr io.Writer
+}
+
+var defaultImplicit implicitValues
+
+var (
+	sysType    = reflect.TypeOf((*Sys)(nil)).Elem()
+	stdoutType = reflect.TypeOf((*Stdout)(nil)).Elem()
+	stderrType = reflect.TypeOf((*Stderr)(nil)).Elem()
+)
+
+// isImplicit reports whether t is the type of an implicit parameter.
+func isImplicit(t reflect.Type) bool {
+	return t == sysType || t == stdoutType || t == stderrType
+}
+
+// value returns the value for the implicit parameter type t.
+func (iv implicitValues) value(t reflect.Type) reflect.Value {
+	var v interface{}
+	switch t {
+	case sysType:
+		var sys Sys = OS
+		if iv.sys != nil {
+			sys = iv.sys
+		}
+		v = &sys
+	case stdoutType:
+		var w Stdout = os.Stdout
+		if iv.stdout != nil {
+			w = iv.stdout
+		}
+		v = &w
+	case stderrType:
+		var w Stderr = os.Stderr
+		if iv.stderr != nil {
+			w = iv.stderr
+		}
+		v = &w
+	}
+	return reflect.ValueOf(v).Elem()
+}
+
+// implicitParams returns the number of leading implicit parameters of the
+// function type t.
+func implicitParams(t reflect.Type) int {
+	if t == nil || t.Kind() != reflect.Func {
+		return 0
+	}
+	n := 0
+	for n < t.NumIn() {
+		if !isImplicit(t.In(n)) {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// bind returns f with its implicit parameters bound to the values of iv.
+// If f has none, it is returned as it is. A *Function is copied, and
+// Overloads are bound one by one.
+func (iv implicitValues) bind(f interface{}) interface{} {
+	switch v := f.(type) {
+	case Overloads:
+		fs := make(Overloads, len(v))
+		for i, g := range v {
+			fs[i] = iv.bind(g)
+		}
+		return fs
+	case *Function:
+		if implicitParams(reflect.TypeOf(v.fn)) == 0 {
+			return v
+		}
+		c := *v
+		c.fn = iv.bind(v.fn)
+		return &c
+	}
+
+	t := reflect.TypeOf(f)
+	n := implicitParams(t)
+	if n == 0 {
+		return f
+	}
+	in := make([]reflect.Type, t.NumIn()-n)
+	for i := range in {
+		in[i] = t.In(n + i)
+	}
+	out := make([]reflect.Type, t.NumOut())
+	for i := range out {
+		out[i] = t.Out(i)
+	}
+	vf := reflect.ValueOf(f)
+	ft := reflect.FuncOf(in, out, t.IsVariadic())
+	return reflect.MakeFunc(ft, func(args []reflect.Value) []reflect.Value {
+		all := make([]reflect.Value, n, n+len(args))
+		for i := range all {
+			all[i] = iv.value(t.In(i))
+		}
+		args = append(all, args...)
+		if t.IsVariadic() {
+			return vf.CallSlice(args)
+		}
+		return vf.Call(args)
+	}).Interface()
+}
+
+// implicit returns the values of the implicit parameters of functions
+// exported to e.
+func (e *Executer) implicit() implicitValues {
+	return implicitValues{sys: e.sys, stdout: e.stdout, stderr: e.stderr}
+}
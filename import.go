@@ -0,0 +1,225 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	past "github.com/goulash/pre/ast"
+
+	"gopkg.in/twik.v1"
+	"gopkg.in/twik.v1/ast"
+)
+
+// ModuleExt is the file extension of modules that can be imported.
+const ModuleExt = ".twik"
+
+// ImportError is returned when a module cannot be imported.
+type ImportError struct {
+	Module string
+	Err    error
+}
+
+func (e ImportError) Error() string { return fmt.Sprintf("cannot import %q: %v", e.Module, e.Err) }
+
+type modulePath struct {
+	dir  string
+	fsys fs.FS
+}
+
+// AddModuleDir appends dir to the module search path.
+func (e *Executer) AddModuleDir(dir string) {
+	e.modpath = append(e.modpath, modulePath{dir: dir, fsys: os.DirFS(dir)})
+}
+
+// AddModuleFS appends fsys to the module search path.
+func (e *Executer) AddModuleFS(fsys fs.FS) {
+	e.modpath = append(e.modpath, modulePath{fsys: fsys})
+}
+
+// importFn implements the import builtin:
+//
+//  (import "name")
+//
+// The first file name.twik found in the module search path is evaluated
+// once per Executer in a scope of its own, which has the exported functions
+// but none of the variables of the importer. Every variable and function it
+// defines at the top level with var or func is then made available
+// globally as name/symbol, and in the scope of the import. Imports of a
+// module that is still being imported result in an import cycle error.
+func (e *Executer) importFn(s *twik.Scope, args []ast.Node) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errors.New("import takes a single string argument")
+	}
+	v, err := s.Eval(args[0])
+	if err != nil {
+		return nil, err
+	}
+	name, ok := v.(string)
+	if !ok {
+		return nil, errors.New("import takes a single string argument")
+	}
+	if err := e.Import(name); err != nil {
+		return nil, err
+	}
+	// Modules that import other modules only see them in their own scope.
+	for _, b := range e.modules[name] {
+		if _, err := s.Get(b.name); err != nil {
+			s.Create(b.name, b.value)
+		}
+	}
+	return nil, nil
+}
+
+// binding is a symbol that an imported module defines.
+type binding struct {
+	name  string // module/symbol
+	value interface{}
+}
+
+// Import imports the module name as if (import "name") were evaluated.
+// If the module fails, none of its symbols are defined.
+func (e *Executer) Import(name string) error {
+	for i, m := range e.loading {
+		if m == name {
+			cycle := append(append([]string(nil), e.loading[i:]...), name)
+			return ImportError{name, fmt.Errorf("import cycle: %s", strings.Join(cycle, " -> "))}
+		}
+	}
+	if _, ok := e.modules[name]; ok {
+		return nil
+	}
+
+	file, code, root, err := e.findModule(name)
+	if err != nil {
+		return ImportError{name, err}
+	}
+
+	e.loading = append(e.loading, name)
+	defer func() { e.loading = e.loading[:len(e.loading)-1] }()
+
+	p, err := e.compile(file, code, root)
+	if err != nil {
+		return ImportError{name, err}
+	}
+	ms := e.moduleScope()
+	if err = e.run(p, ms); err != nil {
+		// Errors that carry a position already point into the module.
+		if _, ok := err.(*twik.Error); ok {
+			return err
+		}
+		return ImportError{name, err}
+	}
+	var bs []binding
+	for _, sym := range definitions(p.node) {
+		v, err := ms.Get(sym)
+		if err != nil {
+			continue
+		}
+		b := binding{name + "/" + sym, v}
+		if _, err := e.scope.Get(b.name); err == nil {
+			return ImportError{name, fmt.Errorf("%s is already defined", b.name)}
+		}
+		bs = append(bs, b)
+	}
+	for _, b := range bs {
+		e.scope.Create(b.name, b.value)
+	}
+	e.modules[name] = bs
+	return nil
+}
+
+// moduleScope returns a new scope for a module, with the builtins and the
+// exported functions of e, but none of its variables.
+func (e *Executer) moduleScope() *twik.Scope {
+	s := newScope(e.fset.eval)
+	for k, v := range e.builtins() {
+		s.Create(k, v)
+	}
+	for k, v := range e.funcs {
+		if v != nil {
+			s.Create(k, e.adapter(k, v))
+		}
+	}
+	if e.probes != nil {
+		s.Create(probeName, e.probeForm)
+	}
+	if h := e.hooks; h != nil && h.OnSet != nil {
+		s.Set("var", e.setHook(varFn))
+		s.Set("set", e.setHook(setFn))
+	}
+	return s
+}
+
+// findModule searches the module path for name and returns the file name,
+// the (preprocessed) code, and the preprocessed root if applicable.
+func (e *Executer) findModule(name string) (file, code string, root past.Node, err error) {
+	rel := path.Clean(name) + ModuleExt
+	if !fs.ValidPath(rel) {
+		return "", "", nil, errors.New("invalid module name")
+	}
+	for _, mp := range e.modpath {
+		bs, err := fs.ReadFile(mp.fsys, rel)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return "", "", nil, err
+		}
+
+		if mp.dir != "" {
+			// Modules from a directory can include other files from the
+			// operating system, just as ExecString does.
+			file = filepath.Join(mp.dir, filepath.FromSlash(rel))
+			code = string(bs)
+			if e.PreProcessor != nil {
+				root, err = e.PreProcessor.ParseString(file, code)
+				if err != nil {
+					return "", "", nil, err
+				}
+				code = root.String()
+			}
+			return file, code, root, nil
+		}
+		if e.PreProcessor != nil {
+			root, err = preprocessFS(e.PreProcessor, mp.fsys, rel, string(bs))
+			if err != nil {
+				return "", "", nil, err
+			}
+			return rel, root.String(), root, nil
+		}
+		return rel, string(bs), nil, nil
+	}
+	return "", "", nil, errors.New("module not found in search path")
+}
+
+// definitions returns the symbols defined at the top level of node
+// with var or func.
+func definitions(node ast.Node) []string {
+	root, ok := node.(*ast.Root)
+	if !ok {
+		return nil
+	}
+	var xs []string
+	for _, n := range root.Nodes {
+		l, ok := n.(*ast.List)
+		if !ok || len(l.Nodes) < 2 {
+			continue
+		}
+		fn, ok := l.Nodes[0].(*ast.Symbol)
+		if !ok || (fn.Name != "var" && fn.Name != "func") {
+			continue
+		}
+		if sym, ok := l.Nodes[1].(*ast.Symbol); ok {
+			xs = append(xs, sym.Name)
+		}
+	}
+	return xs
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil_test
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/goulash/twikutil"
+	"gopkg.in/twik.v1"
+)
+
+func TestImport(z *testing.T) {
+	fsys := fstest.MapFS{
+		"str.twik":     {Data: []byte("(import \"math\")\n(var greeting \"hello\")\n(func twice (x) (math/add x x))\n")},
+		"math.twik":    {Data: []byte("(count)\n(func add (a b) (+ a b))\n")},
+		"cycle/a.twik": {Data: []byte("(import \"cycle/b\")\n")},
+		"cycle/b.twik": {Data: []byte("(import \"cycle/a\")\n")},
+		"broken.twik":  {Data: []byte("(var x 1)\n  (undefined x)\n")},
+	}
+
+	var n int
+	e := twikutil.New(func(_ *twik.Scope) twikutil.FuncMap {
+		return twikutil.FuncMap{"count": func() { n++ }}
+	})
+	e.AddModuleFS(fsys)
+	_, err := e.ExecString("main", `(import "str") (import "math") (var x (str/twice 21)) (var g str/greeting)`)
+	if err != nil {
+		z.Fatal(err)
+	}
+	if v, _ := e.Get("x"); v != int64(42) {
+		z.Errorf("x = %v; want 42", v)
+	}
+	if v, _ := e.Get("g"); v != "hello" {
+		z.Errorf("g = %v; want hello", v)
+	}
+	if n != 1 {
+		z.Errorf("module math evaluated %d times; want 1", n)
+	}
+
+	_, err = e.ExecString("main", `(import "cycle/a")`)
+	if err == nil || !strings.Contains(err.Error(), "cycle/a -> cycle/b -> cycle/a") {
+		z.Errorf("unexpected cycle error: %v", err)
+	}
+	_, err = e.ExecString("main", `(import "broken")`)
+	if err == nil || !strings.HasPrefix(err.Error(), "broken.twik:2:4:") {
+		z.Errorf("unexpected module error: %v", err)
+	}
+	_, err = e.ExecString("main", `(import "missing")`)
+	if err == nil || !strings.Contains(err.Error(), `cannot import "missing"`) {
+		z.Errorf("unexpected missing error: %v", err)
+	}
+
+	// Errors after an import refer to the importing script.
+	e = twikutil.New(noFuncs)
+	e.AddModuleFS(fstest.MapFS{"m.twik": {Data: []byte("(var v 1)\n")}})
+	_, err = e.ExecString("main", "(import \"m\")\n(undefined)")
+	if err == nil || !strings.HasPrefix(err.Error(), "main:2:2:") {
+		z.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestImportScope(z *testing.T) {
+	fsys := fstest.MapFS{
+		"reader.twik":  {Data: []byte("(var v secret)\n")},
+		"setter.twik":  {Data: []byte("(set secret 2)\n")},
+		"partial.twik": {Data: []byte("(var a 1)\n(if (fail) (undefined))\n(var b 2)\n")},
+	}
+	e := twikutil.New(noFuncs)
+	e.AddModuleFS(fsys)
+	e.Set("secret", int64(1))
+	for _, m := range []string{"reader", "setter"} {
+		if err := e.Import(m); err == nil {
+			z.Errorf("module %s could access the variables of the importer", m)
+		}
+	}
+	if v, _ := e.Get("secret"); v != int64(1) {
+		z.Errorf("secret = %v; want 1", v)
+	}
+
+	// A module that fails defines nothing, and can be imported again.
+	e = twikutil.New(func(_ *twik.Scope) twikutil.FuncMap {
+		return twikutil.FuncMap{"fail": func() bool { return partialFails }}
+	})
+	e.AddModuleFS(fsys)
+	partialFails = true
+	if err := e.Import("partial"); err == nil {
+		z.Fatal("expected error from partial")
+	}
+	if _, err := e.Get("partial/a"); err == nil {
+		z.Error("partial/a is defined after the module failed")
+	}
+	partialFails = false
+	if _, err := e.ExecString("main", `(import "partial") (var x (+ partial/a partial/b))`); err != nil {
+		z.Fatal(err)
+	}
+}
+
+var partialFails bool
+
+func TestImportFork(z *testing.T) {
+	e := twikutil.New(noFuncs)
+	e.AddModuleFS(fstest.MapFS{"m.twik": {Data: []byte("(var v 1)\n")}})
+	f := e.Fork()
+	f.AddModuleFS(fstest.MapFS{"n.twik": {Data: []byte("(var v 2)\n")}})
+	if _, err := f.ExecString("main", `(import "m") (import "n") (var x (+ m/v n/v))`); err != nil {
+		z.Fatal(err)
+	}
+	if v, _ := f.Get("x"); v != int64(3) {
+		z.Errorf("x = %v; want 3", v)
+	}
+	if err := f.Import("m"); err != nil {
+		z.Error(err)
+	}
+	for _, k := range []string{"m/v", "n/v"} {
+		if _, err := e.Get(k); err == nil {
+			z.Errorf("import of the fork defined %s in the parent", k)
+		}
+	}
+	if _, err := e.ExecString("main", `(import "n")`); err == nil {
+		z.Error("parent could import a module from the search path of the fork")
+	}
+	if _, err := e.ExecString("main", `(import "m") (var y m/v)`); err != nil {
+		z.Error(err)
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/twik.v1"
+	"gopkg.in/twik.v1/ast"
+)
+
+// Position is a position in a script. If the script was preprocessed,
+// it is the position in the file that the code came from.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// form is a list in an instrumented program, which is evaluated as a
+// function call, or an atom that is a branch of if.
+type form struct {
+	id   int      // unique in the Executer and its forks
+	name string   // name of the function called, or "" if not a symbol
+	pos  Position // opening parenthesis
+	end  Position // closing parenthesis
+	code string
+	node ast.Node
+	atom bool
+	prog *Program
+}
+
+// A probe is called around the evaluation of each form while an Executer
+// is instrumented. It must call eval to evaluate the form and return the
+// result, unless it wants to replace it.
+type probe func(f *form, s *twik.Scope, eval func() (interface{}, error)) (interface{}, error)
+
+// probeName is the name of the special form that wraps each form in an
+// instrumented program. It cannot occur in twik code.
+const probeName = "\x00probe"
+
+// instrumentation contains the forms of the instrumented programs of an
+// Executer and its forks.
+type instrumentation struct {
+	mu    sync.Mutex
+	forms map[ast.Node]*form
+}
+
+func newInstrumentation() *instrumentation {
+	return &instrumentation{forms: make(map[ast.Node]*form)}
+}
+
+func (in *instrumentation) get(n ast.Node) *form {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.forms[n]
+}
+
+// probeEntry is a probe with the value that added it.
+type probeEntry struct {
+	owner interface{}
+	probe probe
+}
+
+// addProbe instruments e with the probe p of owner. Probes are called in
+// the order they were added, each around the next.
+func (e *Executer) addProbe(owner interface{}, p probe) {
+	if e.probes == nil {
+		e.define(probeName, e.probeForm)
+	}
+	e.probes = append(e.probes, probeEntry{owner, p})
+}
+
+// removeProbe removes the probes of owner from e. Programs are still
+// instrumented afterwards, but the forms are evaluated as usual.
+func (e *Executer) removeProbe(owner interface{}) {
+	probes := make([]probeEntry, 0, len(e.probes))
+	for _, p := range e.probes {
+		if p.owner != owner {
+			probes = append(probes, p)
+		}
+	}
+	e.probes = probes
+}
+
+func (e *Executer) probeForm(s *twik.Scope, args []ast.Node) (interface{}, error) {
+	node := args[0]
+	eval := func() (interface{}, error) { return s.Eval(node) }
+	f := e.instr.get(node)
+	if f == nil {
+		return eval()
+	}
+	for i := len(e.probes) - 1; i >= 0; i-- {
+		if _, ok := e.probes[i].owner.(tracker); f.atom && !ok {
+			continue
+		}
+		p, next := e.probes[i].probe, eval
+		eval = func() (interface{}, error) { return p(f, s, next) }
+	}
+	return eval()
+}
+
+// instrumented returns the program p parsed for fset, with each form
+// wrapped in a call to the probe form, and the forms. The forms are
+// registered with in.
+func (p *Program) instrumented(fset *fileSet, in *instrumentation) (ast.Node, []*form, error) {
+	node, err := p.nodeFor(fset)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n, ok := p.inodes[fset]; ok {
+		return n.node, n.forms, nil
+	}
+	if p.inodes == nil {
+		p.inodes = make(map[*fileSet]instrumentedNode)
+	}
+	base := node.Pos()
+	pos := func(x ast.Pos) Position { return p.position(int(x - base)) }
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	var forms []*form
+	probe := func(n ast.Node, f *form) ast.Node {
+		in.forms[n] = f
+		forms = append(forms, f)
+		return &ast.List{
+			LParens: n.Pos(),
+			RParens: n.End() - 1,
+			Nodes:   []ast.Node{&ast.Symbol{Name: probeName, NamePos: n.Pos()}, n},
+		}
+	}
+	var wrap func(n ast.Node) ast.Node
+	wrapAll := func(ns []ast.Node, skip int) []ast.Node {
+		xs := make([]ast.Node, len(ns))
+		for i, n := range ns {
+			if i == skip {
+				xs[i] = n
+			} else {
+				xs[i] = wrap(n)
+			}
+		}
+		return xs
+	}
+	wrap = func(n ast.Node) ast.Node {
+		switch n := n.(type) {
+		case *ast.Root:
+			return &ast.Root{First: n.First, After: n.After, Nodes: wrapAll(n.Nodes, -1)}
+		case *ast.List:
+			if len(n.Nodes) == 0 {
+				return n
+			}
+			skip := rawList(n)
+			c, ok := catchClause(n)
+			if ok {
+				skip = len(n.Nodes) - 1
+			}
+			l := &ast.List{LParens: n.LParens, RParens: n.RParens, Nodes: wrapAll(n.Nodes, skip)}
+			if ok {
+				// The catch clause is not a form, but its handlers are.
+				l.Nodes[skip] = &ast.List{
+					LParens: c.LParens,
+					RParens: c.RParens,
+					Nodes:   append(c.Nodes[:2:2], wrapAll(c.Nodes[2:], -1)...),
+				}
+			}
+			if sym, ok := n.Nodes[0].(*ast.Symbol); ok && sym.Name == "if" {
+				// Branches that are atoms are forms for coverage.
+				for i := 2; i < len(n.Nodes); i++ {
+					if _, ok := n.Nodes[i].(*ast.List); !ok {
+						a := n.Nodes[i]
+						l.Nodes[i] = probe(a, &form{
+							id:   len(in.forms),
+							pos:  pos(a.Pos()),
+							end:  pos(a.End() - 1),
+							code: p.code[a.Pos()-base : a.End()-base],
+							node: a,
+							atom: true,
+							prog: p,
+						})
+					}
+				}
+			}
+			f := &form{
+				id:   len(in.forms),
+				pos:  pos(n.LParens),
+				end:  pos(n.RParens),
+				code: p.code[n.LParens-base : n.RParens-base+1],
+				node: l,
+				prog: p,
+			}
+			if sym, ok := n.Nodes[0].(*ast.Symbol); ok {
+				f.name = sym.Name
+			}
+			return probe(l, f)
+		default:
+			return n
+		}
+	}
+	n := wrap(node)
+	p.inodes[fset] = instrumentedNode{n, forms}
+	if p.syms == nil {
+		p.syms = symbols(node)
+	}
+	return n, forms, nil
+}
+
+type instrumentedNode struct {
+	node  ast.Node
+	forms []*form
+}
+
+// A tracker is the owner of a probe that wants to know about all forms of
+// the programs that are run, including those that are not evaluated. Only
+// the probes of trackers are called for atoms.
+type tracker interface {
+	track(forms []*form, p *Program)
+}
+
+// track tells the trackers among the owners of probes of e about the forms
+// of the program p.
+func (e *Executer) track(forms []*form, p *Program) {
+	for _, pe := range e.probes {
+		if t, ok := pe.owner.(tracker); ok {
+			t.track(forms, p)
+		}
+	}
+}
+
+// rawList returns the index of the argument of the form l that the special
+// form it calls expects to be a list that is not evaluated, or -1.
+func rawList(l *ast.List) int {
+	sym, ok := l.Nodes[0].(*ast.Symbol)
+	if !ok {
+		return -1
+	}
+	switch sym.Name {
+	case "func":
+		if len(l.Nodes) > 1 {
+			if _, ok := l.Nodes[1].(*ast.Symbol); ok {
+				return 2
+			}
+		}
+		return 1
+	case "range":
+		return 1
+	}
+	return -1
+}
+
+// catchClause returns the catch clause of l, if l is a try form with one.
+func catchClause(l *ast.List) (*ast.List, bool) {
+	sym, ok := l.Nodes[0].(*ast.Symbol)
+	if !ok || sym.Name != "try" || len(l.Nodes) < 2 {
+		return nil, false
+	}
+	c, ok := l.Nodes[len(l.Nodes)-1].(*ast.List)
+	if !ok || !isCatch(c) || len(c.Nodes) < 2 {
+		return nil, false
+	}
+	return c, true
+}
+
+// position returns the position of the byte at offset in the code of p.
+func (p *Program) position(offset int) Position {
+	if offset > len(p.code) {
+		offset = len(p.code)
+	}
+	code := p.code[:offset]
+	pos := Position{File: p.name, Line: 1 + strings.Count(code, "\n")}
+	if i := strings.LastIndex(code, "\n"); i >= 0 {
+		pos.Column = offset - i
+	} else {
+		pos.Column = 1 + offset
+	}
+	if p.root != nil {
+		if pi := p.root.OffsetLC(pos.Line, pos.Column); pi != nil {
+			return Position{pi.Name, pi.Line, pi.Column}
+		}
+	}
+	return pos
+}
+
+// symbols returns the names of all symbols in node, sorted.
+func symbols(node ast.Node) []string {
+	seen := make(map[string]bool)
+	var xs []string
+	walk(node, func(n ast.Node) {
+		if sym, ok := n.(*ast.Symbol); ok && !seen[sym.Name] {
+			seen[sym.Name] = true
+			xs = append(xs, sym.Name)
+		}
+	})
+	sort.Strings(xs)
+	return xs
+}
+
+// symbols returns the names of all symbols in the program, if it has
+// been instrumented.
+func (p *Program) symbols() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.syms
+}
@@ -0,0 +1,157 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+)
+
+// builtinDocs documents the functions built into twik and the Executer,
+// which are not part of any FuncMap.
+var builtinDocs = map[string]string{
+	"true":   "The boolean true value.",
+	"false":  "The boolean false value; the only value that if considers false.",
+	"nil":    "The nil value.",
+	"error":  "(error msg) returns an error with the message msg, aborting evaluation.",
+	"==":     "(== a b) reports whether a and b are equal.",
+	"!=":     "(!= a b) reports whether a and b are not equal.",
+	"+":      "(+ x ...) returns the sum of the numbers x, ....",
+	"-":      "(- x ...) subtracts the following numbers from x, or negates a single x.",
+	"*":      "(* x ...) returns the product of the numbers x, ....",
+	"/":      "(/ x y ...) divides x by the following numbers.",
+	"or":     "(or x ...) returns the first x that is not false.",
+	"and":    "(and x ...) returns false if any x is false, otherwise the last x.",
+	"if":     "(if cond then [else]) evaluates then if cond is not false, and else otherwise.",
+	"var":    "(var name [value]) defines the variable name in the current scope.",
+	"set":    "(set name value) sets the existing variable name to value.",
+	"do":     "(do expr ...) evaluates each expr in a new scope and returns the last value.",
+	"func":   "(func [name] (param ...) body ...) defines a function.",
+	"for":    "(for init test step body ...) loops as long as test is not false.",
+	"range":  "(range i n body ...) or (range (i x) list body ...) loops over integers or a list.",
+	"import": "(import \"name\") imports name.twik from the module search path as name/symbol.",
+	"try":    "(try body ... [(catch e handler ...)]) evaluates handler with the error e if body fails.",
+	"raise":  "(raise msg) fails with the message msg, or with an error e caught by try.",
+
+	"error-message": "(error-message e) returns the message of an error e caught by try.",
+}
+
+// Builtins returns the names of the functions built into twik and the
+// Executer, sorted.
+func Builtins() []string {
+	names := make([]string, 0, len(builtinDocs))
+	for k := range builtinDocs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Introspection returns functions that let scripts discover which
+// functions have been exported to e:
+//
+//  (help "name")       returns the signature and documentation of name
+//  (type-of x)         returns the name of the type of x
+//  (functions)         returns the names of all exported functions
+//  (apropos "regex")   returns the names of functions whose name or
+//                      documentation matches regex
+//
+// Documentation comes from functions exported as a *Function. To make
+// the functions available, export them to e:
+//
+//  e.Export(e.Introspection())
+func (e *Executer) Introspection() FuncMap {
+	return FuncMap{
+		"help": Fn(e.Help).Params("name").
+			Doc("Return the signature and documentation of the function name.").
+			Example(`(help "help")`),
+		"type-of": Fn(typeOf).Params("x").
+			Doc("Return the name of the type of x.").
+			Example(`(type-of "abc")`),
+		"functions": Fn(e.functionNames).
+			Doc("Return a list of the names of all exported functions."),
+		"apropos": Fn(e.apropos).Params("regex").
+			Doc("Return the names of functions whose name or documentation matches regex.").
+			Example(`(apropos "file")`),
+	}
+}
+
+// Help returns the signature and documentation of the exported or
+// builtin function name.
+func (e *Executer) Help(name string) (string, error) {
+	v, exported := e.funcs[name]
+	if v == nil {
+		if doc, ok := builtinDocs[name]; ok {
+			return name + " (builtin)\n\n" + doc, nil
+		}
+		if !exported {
+			return "", fmt.Errorf("no function with name %s", name)
+		}
+		return name, nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(Format(name, v))
+	fn := FunctionOf(v)
+	if doc := fn.Documentation(); doc != "" {
+		buf.WriteString("\n\n")
+		buf.WriteString(doc)
+	}
+	if xs := fn.Examples(); len(xs) != 0 {
+		buf.WriteString("\n\nExamples:\n")
+		for _, x := range xs {
+			buf.WriteString("\n\t")
+			buf.WriteString(x)
+		}
+	}
+	return buf.String(), nil
+}
+
+func (e *Executer) functionNames() []interface{} {
+	names := make([]string, 0, len(e.funcs))
+	for k := range e.funcs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return StringList(names)
+}
+
+func (e *Executer) apropos(pattern string) ([]interface{}, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for k, v := range e.funcs {
+		doc := builtinDocs[k]
+		if v != nil {
+			doc = FunctionOf(v).Documentation()
+		}
+		if re.MatchString(k) || re.MatchString(doc) {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return StringList(names), nil
+}
+
+func typeOf(x interface{}) string {
+	if x == nil {
+		return "nil"
+	}
+	return typeName(reflect.TypeOf(x))
+}
+
+// StringList returns ss as a twik list.
+func StringList(ss []string) []interface{} {
+	xs := make([]interface{}, len(ss))
+	for i, s := range ss {
+		xs[i] = s
+	}
+	return xs
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil_test
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/goulash/twikutil"
+	"gopkg.in/twik.v1"
+)
+
+func TestIntrospection(z *testing.T) {
+	e := twikutil.New(func(_ *twik.Scope) twikutil.FuncMap {
+		return twikutil.FuncMap{
+			"read-file": twikutil.Fn(ioutil.ReadFile).Params("path").
+				Doc("Read the file at path.").
+				Example(`(read-file "a.txt")`),
+			"plain": func(x int64) int64 { return x },
+		}
+	})
+	if err := e.Export(e.Introspection()); err != nil {
+		z.Fatal(err)
+	}
+
+	tests := []struct {
+		Code  string
+		Value interface{}
+	}{
+		{`(help "read-file")`, "read-file :: path:string => []byte\n\nRead the file at path.\n\nExamples:\n\n\t(read-file \"a.txt\")"},
+		{`(help "plain")`, "plain :: int64 => int64"},
+		{`(help "if")`, "if (builtin)\n\n(if cond then [else]) evaluates then if cond is not false, and else otherwise."},
+		{`(type-of 1)`, "int64"},
+		{`(type-of "a")`, "string"},
+		{`(type-of nil)`, "nil"},
+		{`(type-of (functions))`, "[]{}"},
+		{`(functions)`, []interface{}{"apropos", "error-message", "functions", "help", "import", "plain", "raise", "read-file", "try", "type-of"}},
+		{`(apropos "file")`, []interface{}{"read-file"}},
+		{`(apropos "^ty")`, []interface{}{"type-of"}},
+	}
+	e.Set("result", nil)
+	for _, t := range tests {
+		_, err := e.ExecString("test", "(set result "+t.Code+")")
+		if err != nil {
+			z.Errorf("%s: %v", t.Code, err)
+			continue
+		}
+		if v, _ := e.Get("result"); !reflect.DeepEqual(v, t.Value) {
+			z.Errorf("%s = %#v; want %#v", t.Code, v, t.Value)
+		}
+	}
+	if _, err := e.ExecString("test", `(help "undefined")`); err == nil {
+		z.Error("help for undefined function did not fail")
+	}
+}
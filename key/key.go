@@ -36,7 +36,7 @@ type ImplementsError struct {
 }
 
 func (e ImplementsError) Error() string {
-	return fmt.Sprintf("%s: value (type %s) does not implement %s", e.Got, e.Wants)
+	return fmt.Sprintf("%s: value (type %s) does not implement %s", e.Name, e.Got, e.Wants)
 }
 
 type TypeError struct {
@@ -149,6 +149,41 @@ func (km KeyMap) Clobber(e *twikutil.Executer) error {
 	return km.Keys().Clobber(e)
 }
 
+// Clone returns a copy of the key map, where each key is copied as well.
+// Setting a key in the clone does not affect the original.
+func (km KeyMap) Clone() KeyMap {
+	c := make(KeyMap, len(km))
+	for k, v := range km {
+		x := *v
+		c[k] = &x
+	}
+	return c
+}
+
+// Acquirer returns a twikutil.Acquirer for use with a twikutil.Watcher.
+// Before each execution, the keys in km are applied to the Executer;
+// afterwards they are acquired into a clone of km, which is the value
+// the watcher holds. The original km is never modified.
+func (km KeyMap) Acquirer(h errs.Handler) twikutil.Acquirer {
+	errs.Init(&h)
+	return &acquirer{km, h}
+}
+
+type acquirer struct {
+	km KeyMap
+	h  errs.Handler
+}
+
+func (a *acquirer) Prepare(e *twikutil.Executer) error { return a.km.Apply(e) }
+
+func (a *acquirer) Acquire(e *twikutil.Executer) (interface{}, error) {
+	c := a.km.Clone()
+	if err := c.Acquire(e, a.h); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
 // }}}
 
 // Keys {{{
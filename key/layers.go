@@ -0,0 +1,242 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package key
+
+import (
+	"fmt"
+	"io/fs"
+	"reflect"
+
+	"github.com/goulash/pre"
+	"github.com/goulash/twikutil"
+)
+
+// Strategy determines how the value a layer supplies for a key is combined
+// with the value supplied by the layers before it.
+type Strategy int
+
+const (
+	// Override replaces the previous value. This is the default.
+	Override Strategy = iota
+
+	// Append appends the list supplied by a layer to the previous list.
+	Append
+
+	// Merge merges the map supplied by a layer into the previous map.
+	// Where both maps contain a map for the same key, those are merged
+	// recursively; otherwise the later value wins.
+	Merge
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case Override:
+		return "override"
+	case Append:
+		return "append"
+	case Merge:
+		return "merge"
+	default:
+		return "unknown"
+	}
+}
+
+// LayerError wraps an error that occurred while executing a layer.
+type LayerError struct {
+	Layer string
+	Err   error
+}
+
+func (e LayerError) Error() string { return fmt.Sprintf("layer %s: %v", e.Layer, e.Err) }
+
+// Source is a twik script that can be executed as a layer.
+type Source struct {
+	Name string
+
+	exec func(e *twikutil.Executer) error
+}
+
+// FileSource returns a source that executes the file at path.
+func FileSource(path string) Source {
+	return Source{path, func(e *twikutil.Executer) error {
+		_, err := e.Exec(path)
+		return err
+	}}
+}
+
+// StringSource returns a source that executes code under the given name.
+func StringSource(name, code string) Source {
+	return Source{name, func(e *twikutil.Executer) error {
+		_, err := e.ExecString(name, code)
+		return err
+	}}
+}
+
+// FSSource returns a source that executes the file at path in fsys.
+func FSSource(fsys fs.FS, path string) Source {
+	return Source{path, func(e *twikutil.Executer) error {
+		_, err := e.ExecFS(fsys, path)
+		return err
+	}}
+}
+
+// Layers executes an ordered list of sources against a single KeyMap,
+// where later sources take precedence over earlier ones, such as a
+// system, user, and project configuration.
+//
+// Each source is executed in a fresh Executer. Keys using the Override
+// strategy are set to their current value beforehand, so that a layer can
+// read what the layers before it have set. Keys using Append or Merge are
+// set to nil, so that each layer only sets its own contribution.
+type Layers struct {
+	PreProcessor *pre.Processor
+
+	km      KeyMap
+	loader  twikutil.LoaderFunc
+	sources []Source
+	strats  map[string]Strategy
+	origins map[string][]string
+}
+
+func NewLayers(km KeyMap, loader twikutil.LoaderFunc, sources ...Source) *Layers {
+	return &Layers{
+		km:      km,
+		loader:  loader,
+		sources: sources,
+		strats:  make(map[string]Strategy),
+		origins: make(map[string][]string),
+	}
+}
+
+// Add appends sources to the layers; the last source has the highest precedence.
+func (l *Layers) Add(sources ...Source) { l.sources = append(l.sources, sources...) }
+
+// SetStrategy sets the merge strategy for the key name.
+func (l *Layers) SetStrategy(name string, s Strategy) { l.strats[name] = s }
+
+func (l *Layers) Strategy(name string) Strategy { return l.strats[name] }
+
+// Origin returns the name of the layer that supplied the final value of
+// the key name, or the empty string if the value is the default.
+// For Append and Merge keys, this is the last contributing layer.
+func (l *Layers) Origin(name string) string {
+	xs := l.origins[name]
+	if len(xs) == 0 {
+		return ""
+	}
+	return xs[len(xs)-1]
+}
+
+// Origins returns the names of all layers that contributed to the key name.
+func (l *Layers) Origins(name string) []string { return l.origins[name] }
+
+// Exec executes all sources in order and stores the final values in the
+// key map. After all layers have run, required keys that are still
+// empty result in a RequiredError.
+//
+// The layers are executed against a clone of the key map, which is only
+// copied back once every layer has succeeded, so that the key map and
+// the origins remain unchanged on error.
+func (l *Layers) Exec() error {
+	c := l.km.Clone()
+	ks := c.Keys()
+	origins := make(map[string][]string)
+	for _, src := range l.sources {
+		if err := l.exec(ks, src, origins); err != nil {
+			return LayerError{src.Name, err}
+		}
+	}
+	for _, k := range ks {
+		if k.mode&Required != 0 && k.Empty() {
+			return &RequiredError{k.name}
+		}
+	}
+	for name, k := range c {
+		*l.km[name] = *k
+	}
+	l.origins = origins
+	return nil
+}
+
+func (l *Layers) exec(ks Keys, src Source, origins map[string][]string) error {
+	e := twikutil.New(l.loader)
+	e.PreProcessor = l.PreProcessor
+	for _, k := range ks {
+		var v interface{}
+		if k.mode&Write != 0 && l.strats[k.name] == Override {
+			v = k.val
+		}
+		if err := e.Set(k.name, v); err != nil {
+			return err
+		}
+	}
+
+	// A layer that sets a key to the value it already has still
+	// supplies it, so we record what the script sets.
+	set := make(map[string]bool)
+	e.SetHooks(&twikutil.Hooks{OnSet: func(name string, _ interface{}) { set[name] = true }})
+	if err := src.exec(e); err != nil {
+		return err
+	}
+
+	for _, k := range ks {
+		if k.mode&Read == 0 || !set[k.name] {
+			continue
+		}
+		v, _ := e.Get(k.name)
+		switch l.strats[k.name] {
+		case Append:
+			if k.val != nil {
+				a, b := reflect.ValueOf(k.val), reflect.ValueOf(v)
+				if b.Kind() != reflect.Slice || !b.Type().AssignableTo(a.Type()) {
+					return NewTypeError(k.name, v, a.Type())
+				}
+				v = reflect.AppendSlice(a, b).Interface()
+			}
+		case Merge:
+			if k.val != nil {
+				a, b := reflect.ValueOf(k.val), reflect.ValueOf(v)
+				if b.Kind() != reflect.Map || b.Type() != a.Type() {
+					return NewTypeError(k.name, v, a.Type())
+				}
+				v = mergeMaps(a, b).Interface()
+			}
+		}
+		if err := k.Set(v); err != nil {
+			return err
+		}
+		origins[k.name] = append(origins[k.name], src.Name)
+	}
+	return nil
+}
+
+// mergeMaps returns a new map containing the entries of a and b. Where
+// both contain a map for the same key, these are merged recursively.
+func mergeMaps(a, b reflect.Value) reflect.Value {
+	m := reflect.MakeMapWithSize(a.Type(), a.Len()+b.Len())
+	iter := a.MapRange()
+	for iter.Next() {
+		m.SetMapIndex(iter.Key(), iter.Value())
+	}
+	iter = b.MapRange()
+	for iter.Next() {
+		k, v := iter.Key(), iter.Value()
+		if old := m.MapIndex(k); old.IsValid() {
+			x, y := unwrap(old), unwrap(v)
+			if x.Kind() == reflect.Map && y.Kind() == reflect.Map && x.Type() == y.Type() {
+				v = mergeMaps(x, y)
+			}
+		}
+		m.SetMapIndex(k, v)
+	}
+	return m
+}
+
+func unwrap(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v
+}
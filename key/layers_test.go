@@ -0,0 +1,94 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package key_test
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/goulash/twikutil"
+	"github.com/goulash/twikutil/key"
+	"gopkg.in/twik.v1"
+)
+
+func loader(_ *twik.Scope) twikutil.FuncMap {
+	return twikutil.FuncMap{
+		"list": func(xs ...interface{}) []interface{} { return xs },
+		"dict": func(k string, v interface{}) map[string]interface{} {
+			return map[string]interface{}{k: v}
+		},
+	}
+}
+
+func TestLayers(z *testing.T) {
+	km := key.NewKeyMap()
+	key.Must(km.CreateAuto("name", "default", key.ReadWrite, ""))
+	key.Must(km.CreateAuto("paths", []interface{}{"/usr"}, key.ReadWrite, ""))
+	key.Must(km.CreateAuto("env", map[string]interface{}{}, key.ReadWrite, ""))
+
+	fsys := fstest.MapFS{
+		"project.twik": {Data: []byte(`(set paths (list "./bin"))`)},
+	}
+	l := key.NewLayers(km, loader,
+		key.StringSource("system", `(set name "system") (set env (dict "a" (dict "x" 1)))`),
+		key.StringSource("user", `(set name (+ name 1)) (set paths (list "~/bin"))`),
+	)
+	l.Add(
+		key.StringSource("user2", `(set env (dict "a" (dict "y" 2)))`),
+		key.FSSource(fsys, "project.twik"),
+	)
+	l.SetStrategy("paths", key.Append)
+	l.SetStrategy("env", key.Merge)
+
+	// The user layer fails, because name is a string.
+	if err := l.Exec(); err == nil {
+		z.Fatal("expected error from user layer")
+	} else if le, ok := err.(key.LayerError); !ok || le.Layer != "user" {
+		z.Fatalf("unexpected error: %v", err)
+	}
+	if v := km["name"].Get(); v != "default" {
+		z.Errorf("name = %v after failed layer; want default", v)
+	}
+	if o := l.Origin("name"); o != "" {
+		z.Errorf("origin of name = %q after failed layer; want none", o)
+	}
+
+	km = key.NewKeyMap()
+	key.Must(km.CreateAuto("name", "default", key.ReadWrite, ""))
+	key.Must(km.CreateAuto("paths", []interface{}{"/usr"}, key.ReadWrite, ""))
+	key.Must(km.CreateAuto("env", map[string]interface{}{}, key.ReadWrite, ""))
+	l = key.NewLayers(km, loader,
+		key.StringSource("system", `(set name "system") (set env (dict "a" (dict "x" 1)))`),
+		key.StringSource("user", `(set paths (list "~/bin"))`),
+		key.StringSource("user2", `(set env (dict "a" (dict "y" 2)))`),
+		key.StringSource("project", `(set name "system")`),
+		key.FSSource(fsys, "project.twik"),
+	)
+	l.SetStrategy("paths", key.Append)
+	l.SetStrategy("env", key.Merge)
+	if err := l.Exec(); err != nil {
+		z.Fatal(err)
+	}
+
+	if v := km["name"].Get(); v != "system" {
+		z.Errorf("name = %v; want system", v)
+	}
+	// The project layer sets name to the value it already has.
+	if o := l.Origin("name"); o != "project" {
+		z.Errorf("origin of name = %q; want project", o)
+	}
+	want := []interface{}{"/usr", "~/bin", "./bin"}
+	if v := km["paths"].Get(); !reflect.DeepEqual(v, want) {
+		z.Errorf("paths = %v; want %v", v, want)
+	}
+	if o := l.Origins("paths"); !reflect.DeepEqual(o, []string{"user", "project.twik"}) {
+		z.Errorf("origins of paths = %v", o)
+	}
+	env := map[string]interface{}{"a": map[string]interface{}{"x": int64(1), "y": int64(2)}}
+	if v := km["env"].Get(); !reflect.DeepEqual(v, env) {
+		z.Errorf("env = %v; want %v", v, env)
+	}
+}
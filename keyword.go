@@ -0,0 +1,229 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"gopkg.in/twik.v1"
+	"gopkg.in/twik.v1/ast"
+)
+
+// Keyword is the value of a symbol starting with a colon, such as :timeout,
+// in scripts run by an Executer. Keywords name the optional arguments of
+// functions with options, see Function.Options.
+type Keyword string
+
+func (k Keyword) String() string { return ":" + string(k) }
+
+// Options declares that the last parameter of the function is a struct of
+// options, which scripts set with keyword arguments after the positional
+// arguments:
+//
+//  type GetOptions struct {
+//      Timeout    int64
+//      MaxRetries int64 `twik:"retries"`
+//  }
+//
+//  fm["http-get"] = twikutil.Fn(func(url string, opt GetOptions) (string, error) {
+//      ...
+//  }).Params("url").Options(GetOptions{Timeout: 30})
+//
+//  (http-get "http://example.com" :timeout 5 :retries 3)
+//
+// The keyword of an exported field is its name in lower-case words
+// separated by dashes (MaxRetries becomes max-retries), unless it is
+// set with a twik struct tag. Fields tagged with `twik:"-"` are not
+// settable. Options that are not given take their value from defaults,
+// which must be of the option struct type, or are zero if defaults is nil.
+func (fn *Function) Options(defaults interface{}) *Function {
+	t := reflect.TypeOf(fn.fn)
+	if t == nil || t.Kind() != reflect.Func || t.IsVariadic() || t.NumIn() == 0 {
+		panic("Options: function must be non-variadic with an options struct as last parameter")
+	}
+	ot := t.In(t.NumIn() - 1)
+	if ot.Kind() != reflect.Struct {
+		panic("Options: last parameter must be a struct")
+	}
+	v := reflect.New(ot).Elem()
+	if defaults != nil {
+		dv := reflect.ValueOf(defaults)
+		if dv.Type() != ot {
+			panic("Options: defaults must be of type " + ot.String())
+		}
+		v.Set(dv)
+	}
+	fn.options = &options{v, keywordFields(ot)}
+	return fn
+}
+
+type options struct {
+	defaults reflect.Value
+	fields   []keywordField
+}
+
+type keywordField struct {
+	keyword string
+	index   int
+	typ     reflect.Type
+}
+
+func (o *options) field(k Keyword) (keywordField, bool) {
+	for _, f := range o.fields {
+		if f.keyword == string(k) {
+			return f, true
+		}
+	}
+	return keywordField{}, false
+}
+
+func keywordFields(t reflect.Type) []keywordField {
+	var xs []keywordField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Tag.Get("twik")
+		if name == "-" {
+			continue
+		} else if name == "" {
+			name = kebabCase(f.Name)
+		}
+		xs = append(xs, keywordField{name, i, f.Type})
+	}
+	return xs
+}
+
+// kebabCase converts a Go identifier such as MaxRetries to max-retries.
+func kebabCase(s string) string {
+	var buf bytes.Buffer
+	rs := []rune(s)
+	for i, r := range rs {
+		if unicode.IsUpper(r) {
+			// Start a new word unless we're in an acronym, such as URL.
+			if i > 0 && (unicode.IsLower(rs[i-1]) || (i+1 < len(rs) && unicode.IsLower(rs[i+1]))) {
+				buf.WriteByte('-')
+			}
+			r = unicode.ToLower(r)
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// KeywordError is returned when a function with options is called
+// with a keyword it does not know.
+type KeywordError struct {
+	Name    string
+	Fn      interface{}
+	Keyword Keyword
+}
+
+func (e KeywordError) Error() string {
+	var buf bytes.Buffer
+	buf.WriteString("Unknown keyword ")
+	buf.WriteString(e.Keyword.String())
+	buf.WriteString(" to function ")
+	buf.WriteString(e.Name)
+	buf.WriteString(".\n\t")
+	buf.WriteString(Format(e.Name, e.Fn))
+	return buf.String()
+}
+
+func funcKeywords(name string, f *Function, late func(error)) func([]interface{}) (interface{}, error) {
+	ret := funcReturn(name, f)
+	t := reflect.TypeOf(f.fn)
+	in := t.NumIn() - 1
+	vf := reflect.ValueOf(f.fn)
+	opts := f.options
+	return func(args []interface{}) (interface{}, error) {
+		if len(args) < in || (len(args)-in)%2 != 0 {
+			return nil, newParamError(name, f)
+		}
+		cs := &callState{late: late}
+		vi := make([]reflect.Value, in+1)
+		for i := 0; i < in; i++ {
+			v, ok := argValue(cs, t.In(i), args[i])
+			if !ok {
+				return nil, &TypeError{name, f, args[i], []string{typeName(t.In(i))}}
+			}
+			vi[i] = v
+		}
+		ov := reflect.New(opts.defaults.Type()).Elem()
+		ov.Set(opts.defaults)
+		for i := in; i < len(args); i += 2 {
+			k, ok := args[i].(Keyword)
+			if !ok {
+				return nil, newParamError(name, f)
+			}
+			field, ok := opts.field(k)
+			if !ok {
+				return nil, &KeywordError{name, f, k}
+			}
+			v, ok := argValue(cs, field.typ, args[i+1])
+			if !ok {
+				return nil, &TypeError{name, f, args[i+1], []string{typeName(field.typ)}}
+			}
+			ov.Field(field.index).Set(v)
+		}
+		vi[in] = ov
+		return call(cs, vf, vi, ret)
+	}
+}
+
+// formatOptions writes the keywords of an options struct for Format.
+func formatOptions(buf *bytes.Buffer, o *options) {
+	for i, f := range o.fields {
+		if i > 0 {
+			buf.WriteString(" -> ")
+		}
+		buf.WriteString("[:")
+		buf.WriteString(f.keyword)
+		buf.WriteString(" ")
+		buf.WriteString(typeName(f.typ))
+		buf.WriteString("]")
+	}
+}
+
+// keywords returns the names of all keywords used in node.
+func keywords(node ast.Node) []string {
+	seen := make(map[string]bool)
+	var xs []string
+	walk(node, func(n ast.Node) {
+		if s, ok := n.(*ast.Symbol); ok && len(s.Name) > 1 && strings.HasPrefix(s.Name, ":") && !seen[s.Name] {
+			seen[s.Name] = true
+			xs = append(xs, s.Name[1:])
+		}
+	})
+	return xs
+}
+
+// defineKeywords makes sure that each keyword evaluates to itself in s.
+func defineKeywords(s *twik.Scope, kws []string) {
+	for _, k := range kws {
+		if _, err := s.Get(":" + k); err != nil {
+			s.Create(":"+k, Keyword(k))
+		}
+	}
+}
+
+// walk calls f for node and every node below it.
+func walk(node ast.Node, f func(ast.Node)) {
+	f(node)
+	switch n := node.(type) {
+	case *ast.Root:
+		for _, x := range n.Nodes {
+			walk(x, f)
+		}
+	case *ast.List:
+		for _, x := range n.Nodes {
+			walk(x, f)
+		}
+	}
+}
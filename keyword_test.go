@@ -0,0 +1,68 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/goulash/twikutil"
+	"gopkg.in/twik.v1"
+)
+
+type getOptions struct {
+	Timeout    int64
+	MaxRetries int64 `twik:"retries"`
+	UserAgent  string
+	Internal   bool `twik:"-"`
+}
+
+func TestKeywords(z *testing.T) {
+	get := twikutil.Fn(func(url string, o getOptions) string {
+		return fmt.Sprintf("%s %d %d %s", url, o.Timeout, o.MaxRetries, o.UserAgent)
+	}).Params("url").Options(getOptions{Timeout: 30, UserAgent: "twik"})
+
+	sig := "http-get :: url:string -> [:timeout int64] -> [:retries int64] -> [:user-agent string] => string"
+	if f := twikutil.Format("http-get", get); f != sig {
+		z.Errorf("Format = %q; want %q", f, sig)
+	}
+
+	e := twikutil.New(func(_ *twik.Scope) twikutil.FuncMap {
+		return twikutil.FuncMap{"http-get": get}
+	})
+	tests := []struct {
+		Code  string
+		Value interface{}
+	}{
+		{`(http-get "a")`, "a 30 0 twik"},
+		{`(http-get "a" :retries 3 :timeout 5)`, "a 5 3 twik"},
+		{`(http-get "a" :user-agent "curl")`, "a 30 0 curl"},
+	}
+	e.Set("result", nil)
+	for _, t := range tests {
+		if _, err := e.ExecString("test", "(set result "+t.Code+")"); err != nil {
+			z.Errorf("%s: %v", t.Code, err)
+		} else if v, _ := e.Get("result"); v != t.Value {
+			z.Errorf("%s = %#v; want %#v", t.Code, v, t.Value)
+		}
+	}
+
+	errors := []struct {
+		Code string
+		Msg  string
+	}{
+		{`(http-get "a" :internal true)`, "Unknown keyword :internal to function http-get.\n\t" + sig},
+		{`(http-get "a" :timeout "5")`, "Got type string but need type int64"},
+		{`(http-get "a" :timeout)`, "Incorrect number of parameters"},
+		{`(http-get "a" 5 :timeout)`, "Incorrect number of parameters"},
+	}
+	for _, t := range errors {
+		_, err := e.ExecString("test", t.Code)
+		if err == nil || !strings.Contains(err.Error(), t.Msg) {
+			z.Errorf("%s: unexpected error: %v", t.Code, err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package lsp
+
+import "strings"
+
+// indent is the indentation of each level of nesting.
+const indent = "  "
+
+// Format re-indents twik code, so that each line is indented by the number
+// of lists that are open at its start. Lines that start by closing a list
+// are indented like the line that opened it. Preprocessor directives are
+// not indented, and lines that continue a string are left alone. Trailing
+// whitespace is removed, and the code ends with a single newline.
+func Format(code string) string {
+	var buf strings.Builder
+	depth := 0
+	inString := false
+	for _, line := range strings.Split(strings.TrimRight(code, " \t\r\n"), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if inString {
+			buf.WriteString(line)
+		} else {
+			line = strings.TrimLeft(line, " \t")
+			if line != "" && line[0] != '#' {
+				d := depth - leadingClosers(line)
+				if d < 0 {
+					d = 0
+				}
+				buf.WriteString(strings.Repeat(indent, d))
+			}
+			buf.WriteString(line)
+		}
+		var delta int
+		delta, inString = scanLine(line, inString)
+		if depth += delta; depth < 0 {
+			depth = 0
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// leadingClosers returns the number of parentheses that line starts with
+// that close a list.
+func leadingClosers(line string) int {
+	n := 0
+	for _, r := range line {
+		switch r {
+		case ')':
+			n++
+		case ' ', '\t':
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// scanLine returns by how much line changes the nesting depth, and whether
+// a string is still open at its end. Parentheses in strings, character
+// literals, and comments are ignored.
+func scanLine(line string, inString bool) (delta int, open bool) {
+	escaped := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case ';':
+			return delta, false
+		case '\'':
+			// Skip a character literal such as '(' or '\''.
+			if j := strings.IndexByte(line[i+1:], '\''); j >= 0 {
+				if line[i+1] == '\\' && j == 1 {
+					j = 2
+				}
+				i += j + 1
+			}
+		case '(':
+			delta++
+		case ')':
+			delta--
+		}
+	}
+	return delta, inString
+}
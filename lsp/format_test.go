@@ -0,0 +1,30 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package lsp_test
+
+import (
+	"testing"
+
+	"github.com/goulash/twikutil/lsp"
+)
+
+func TestFormat(z *testing.T) {
+	tests := []struct {
+		code, want string
+	}{
+		{"(var x 1)", "(var x 1)\n"},
+		{"(func f (a)\n(+ a 1))\n\n\n", "(func f (a)\n  (+ a 1))\n"},
+		{"(do\n    (if true\n(println \"(\")\n  )  \n)", "(do\n  (if true\n    (println \"(\")\n  )\n)\n"},
+		{"(do ; (\n(var c '(')\n(var d '\\''))", "(do ; (\n  (var c '(')\n  (var d '\\''))\n"},
+		{"(do\n  #include \"a.twik\"\n(f))", "(do\n#include \"a.twik\"\n  (f))\n"},
+		{"(var s \"a\n   (b\n\")\n  (f)", "(var s \"a\n   (b\n\")\n(f)\n"},
+		{"))\n(f)", "))\n(f)\n"},
+	}
+	for _, t := range tests {
+		if got := lsp.Format(t.code); got != t.want {
+			z.Errorf("Format(%q) = %q; want %q", t.code, got, t.want)
+		}
+	}
+}
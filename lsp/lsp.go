@@ -0,0 +1,459 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+// Package lsp implements a Language Server Protocol server for twik files.
+//
+// The server provides diagnostics for syntax errors, hover information and
+// completion for functions and configuration keys, go-to-definition for
+// var and func bindings and included files, and document formatting:
+//
+//  s := lsp.NewServer(stdlib.All, km)
+//  err := s.Serve(os.Stdin, os.Stdout)
+//
+// Documents are synchronized in full, and positions are counted in UTF-16
+// code units, as the protocol requires.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/goulash/pre"
+	"github.com/goulash/twikutil"
+	"github.com/goulash/twikutil/key"
+	"gopkg.in/twik.v1"
+)
+
+// DefaultMaxMessageSize is the largest message in bytes that a server
+// accepts unless MaxMessageSize is changed.
+const DefaultMaxMessageSize = 64 << 20
+
+// Server is a language server for twik files.
+type Server struct {
+	// MaxMessageSize is the largest message in bytes that the server
+	// accepts. Larger messages are skipped and answered with an error.
+	MaxMessageSize int
+
+	funcs twikutil.FuncMap
+	keys  key.KeyMap
+
+	mu   sync.Mutex
+	w    io.Writer
+	docs map[string]string // text by URI
+	exit bool
+}
+
+// NewServer returns a server for scripts that are run with the functions
+// in funcs and configure the keys in km. Either may be nil.
+func NewServer(funcs twikutil.FuncMap, km key.KeyMap) *Server {
+	return &Server{
+		MaxMessageSize: DefaultMaxMessageSize,
+		funcs:          funcs,
+		keys:           km,
+		docs:           make(map[string]string),
+	}
+}
+
+// Serve reads requests from r and writes responses to w until the client
+// asks the server to exit, or r is closed.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	s.w = w
+	br := bufio.NewReader(r)
+	for !s.exit {
+		msg, err := readMessage(br, s.MaxMessageSize)
+		if err == io.EOF {
+			return nil
+		}
+		if err == errTooLarge {
+			s.reply(nil, nil, &rpcError{-32600, err.Error()})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(msg, &req); err != nil {
+			s.reply(nil, nil, &rpcError{-32700, err.Error()})
+			continue
+		}
+		result, err := s.handle(req.Method, req.Params)
+		if req.ID == nil {
+			continue // notification
+		}
+		if err != nil {
+			s.reply(req.ID, nil, &rpcError{-32603, err.Error()})
+			continue
+		}
+		s.reply(req.ID, result, nil)
+	}
+	return nil
+}
+
+type request struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+}
+
+type response struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+	Error   *rpcError        `json:"error,omitempty"`
+}
+
+type notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+var (
+	errUnknownMethod = errors.New("unknown method")
+	errTooLarge      = errors.New("message too large")
+)
+
+// readMessage reads a message of at most max bytes from r. The content of
+// larger messages is discarded, and errTooLarge is returned.
+func readMessage(r *bufio.Reader, max int) ([]byte, error) {
+	n := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if v := strings.TrimPrefix(line, "Content-Length:"); v != line {
+			n, err = strconv.Atoi(strings.TrimSpace(v))
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid header: %s", line)
+			}
+		}
+	}
+	if n < 0 {
+		return nil, errors.New("missing Content-Length header")
+	}
+	if n > max {
+		if _, err := io.CopyN(ioutil.Discard, r, int64(n)); err != nil {
+			return nil, err
+		}
+		return nil, errTooLarge
+	}
+	msg := make([]byte, n)
+	_, err := io.ReadFull(r, msg)
+	return msg, err
+}
+
+// write sends v to the client. If the result of a response cannot be
+// encoded, an error is sent in its place; notifications that cannot be
+// encoded are dropped.
+func (s *Server) write(v interface{}) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		r, ok := v.(response)
+		if !ok {
+			return
+		}
+		bs, _ = json.Marshal(response{"2.0", r.ID, nil, &rpcError{-32603, "cannot encode result: " + err.Error()}})
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(bs), bs)
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}, err *rpcError) {
+	s.write(response{"2.0", id, result, err})
+}
+
+func (s *Server) notify(method string, params interface{}) {
+	s.write(notification{"2.0", method, params})
+}
+
+// Protocol types {{{
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type rng struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type location struct {
+	URI   string `json:"uri"`
+	Range rng    `json:"range"`
+}
+
+type textDocument struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type positionParams struct {
+	TextDocument textDocument `json:"textDocument"`
+	Position     position     `json:"position"`
+}
+
+type diagnostic struct {
+	Range    rng    `json:"range"`
+	Severity int    `json:"severity"`
+	Source   string `json:"source"`
+	Message  string `json:"message"`
+}
+
+type completionItem struct {
+	Label         string `json:"label"`
+	Kind          int    `json:"kind"`
+	Detail        string `json:"detail,omitempty"`
+	Documentation string `json:"documentation,omitempty"`
+}
+
+type textEdit struct {
+	Range   rng    `json:"range"`
+	NewText string `json:"newText"`
+}
+
+// Completion item kinds.
+const (
+	kindFunction = 3
+	kindVariable = 6
+	kindProperty = 10
+	kindKeyword  = 14
+)
+
+// }}}
+
+func (s *Server) handle(method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":           1,
+				"hoverProvider":              true,
+				"completionProvider":         map[string]interface{}{"triggerCharacters": []string{"("}},
+				"definitionProvider":         true,
+				"documentFormattingProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "twik"},
+		}, nil
+	case "initialized", "$/cancelRequest", "$/setTrace", "workspace/didChangeConfiguration":
+		return nil, nil
+	case "shutdown":
+		return nil, nil
+	case "exit":
+		s.exit = true
+		return nil, nil
+	case "textDocument/didOpen", "textDocument/didChange", "textDocument/didClose":
+		var p struct {
+			TextDocument   textDocument `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		uri := p.TextDocument.URI
+		switch method {
+		case "textDocument/didOpen":
+			s.docs[uri] = p.TextDocument.Text
+		case "textDocument/didChange":
+			if n := len(p.ContentChanges); n > 0 {
+				s.docs[uri] = p.ContentChanges[n-1].Text
+			}
+		default:
+			delete(s.docs, uri)
+			s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+				"uri": uri, "diagnostics": []diagnostic{},
+			})
+			return nil, nil
+		}
+		s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+			"uri": uri, "diagnostics": s.diagnostics(uri, s.docs[uri]),
+		})
+		return nil, nil
+	case "textDocument/hover", "textDocument/completion", "textDocument/definition":
+		var p positionParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		text, ok := s.docs[p.TextDocument.URI]
+		if !ok {
+			return nil, fmt.Errorf("unknown document: %s", p.TextDocument.URI)
+		}
+		offset := toOffset(text, p.Position)
+		switch method {
+		case "textDocument/hover":
+			return s.hover(text, offset), nil
+		case "textDocument/completion":
+			return s.completion(text, offset), nil
+		default:
+			return s.definition(p.TextDocument.URI, text, offset), nil
+		}
+	case "textDocument/formatting":
+		var p positionParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		text, ok := s.docs[p.TextDocument.URI]
+		if !ok {
+			return nil, fmt.Errorf("unknown document: %s", p.TextDocument.URI)
+		}
+		formatted := Format(text)
+		if formatted == text {
+			return []textEdit{}, nil
+		}
+		end := toPosition(text, len(text))
+		return []textEdit{{rng{position{}, end}, formatted}}, nil
+	}
+	return nil, errUnknownMethod
+}
+
+// newExecuter returns an Executer for the functions of s.
+func (s *Server) newExecuter() *twikutil.Executer {
+	e := twikutil.New(func(*twik.Scope) twikutil.FuncMap { return s.funcs })
+	e.PreProcessor = pre.New()
+	return e
+}
+
+var errorPos = regexp.MustCompile(`(?s)^(.*?):(\d+):(\d+): (.*)$`)
+
+// diagnostics returns the syntax errors in the document. Included files
+// are read from the file system; errors in them are reported at the first
+// line of the document.
+func (s *Server) diagnostics(uri, text string) []diagnostic {
+	ds := []diagnostic{}
+	path := uriPath(uri)
+	_, err := s.newExecuter().Compile(path, text)
+	if err == nil {
+		return ds
+	}
+	d := diagnostic{Severity: 1, Source: "twik", Message: err.Error()}
+	if m := errorPos.FindStringSubmatch(err.Error()); m != nil {
+		if m[1] == path {
+			line, _ := strconv.Atoi(m[2])
+			col, _ := strconv.Atoi(m[3])
+			start := toPosition(text, byteOffset(text, line, col))
+			d.Range = rng{start, position{start.Line, start.Character + 1}}
+			d.Message = m[4]
+		}
+	}
+	return append(ds, d)
+}
+
+// hover returns the signature and documentation of the function or the
+// description of the key at offset.
+func (s *Server) hover(text string, offset int) interface{} {
+	word, start, end := wordAt(text, offset)
+	if word == "" {
+		return nil
+	}
+	var value string
+	if k, ok := s.keys[word]; ok {
+		value = fmt.Sprintf("%s : %s\n\n%s", k.Name(), k.Type(), k.Desc())
+	} else if help, err := s.newExecuter().Help(word); err == nil {
+		value = help
+	} else {
+		return nil
+	}
+	return map[string]interface{}{
+		"contents": map[string]string{"kind": "plaintext", "value": value},
+		"range":    rng{toPosition(text, start), toPosition(text, end)},
+	}
+}
+
+// completion returns the functions, keys, and variables that start with
+// the word before offset.
+func (s *Server) completion(text string, offset int) []completionItem {
+	word, start, _ := wordAt(text, offset)
+	prefix := word[:offset-start]
+	items := []completionItem{}
+	seen := make(map[string]bool)
+	add := func(name string, item completionItem) {
+		if seen[name] || !strings.HasPrefix(name, prefix) {
+			return
+		}
+		seen[name] = true
+		item.Label = name
+		items = append(items, item)
+	}
+	for _, name := range twikutil.Builtins() {
+		add(name, completionItem{Kind: kindKeyword, Detail: "builtin"})
+	}
+	for _, name := range s.funcs.Keys() {
+		add(name, completionItem{Kind: kindFunction, Detail: twikutil.Format(name, s.funcs[name])})
+	}
+	for _, name := range s.keys.KeyNames() {
+		k := s.keys[name]
+		add(name, completionItem{Kind: kindProperty, Detail: k.Type(), Documentation: k.Desc()})
+	}
+	for _, b := range bindings(text) {
+		add(b.name, completionItem{Kind: kindVariable})
+	}
+	return items
+}
+
+var includeLine = regexp.MustCompile(`^\s*#\s*(?:include|require)\s+"([^"]+)"`)
+
+// definition returns the location of the var or func binding of the symbol
+// at offset, or of the file included on the line.
+func (s *Server) definition(uri, text string, offset int) interface{} {
+	lineStart := strings.LastIndexByte(text[:offset], '\n') + 1
+	lineEnd := strings.IndexByte(text[offset:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(text)
+	} else {
+		lineEnd += offset
+	}
+	if m := includeLine.FindStringSubmatch(text[lineStart:lineEnd]); m != nil {
+		path := m[1]
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(uriPath(uri)), path)
+		}
+		return location{pathURI(path), rng{}}
+	}
+
+	word, _, _ := wordAt(text, offset)
+	for _, b := range bindings(text) {
+		if b.name == word {
+			start := toPosition(text, b.offset)
+			end := toPosition(text, b.offset+len(b.name))
+			return location{uri, rng{start, end}}
+		}
+	}
+	return nil
+}
+
+// uriPath returns the file path of a file URI.
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func pathURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package lsp_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/goulash/twikutil"
+	"github.com/goulash/twikutil/key"
+	"github.com/goulash/twikutil/lsp"
+)
+
+const uri = "file:///project/main.twik"
+
+// session runs a server with the messages and returns the messages it
+// writes, decoded.
+func session(z *testing.T, msgs ...string) []map[string]interface{} {
+	funcs := twikutil.FuncMap{
+		"upper": twikutil.Fn(strings.ToUpper).Params("s").Doc("Return s in upper case."),
+	}
+	km := key.NewKeyMap()
+	key.Must(km.CreateAuto("verbose", false, key.ReadWrite, "Print more."))
+	return serve(z, lsp.NewServer(funcs, km), msgs...)
+}
+
+// serve runs s with the messages and returns the messages it writes,
+// decoded.
+func serve(z *testing.T, s *lsp.Server, msgs ...string) []map[string]interface{} {
+	var in bytes.Buffer
+	for _, m := range msgs {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(m), m)
+	}
+	var out bytes.Buffer
+	if err := s.Serve(&in, &out); err != nil {
+		z.Fatal(err)
+	}
+
+	var xs []map[string]interface{}
+	r := bufio.NewReader(&out)
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			return xs
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Content-Length:")))
+		if err != nil {
+			z.Fatalf("invalid header %q", line)
+		}
+		r.ReadString('\n')
+		bs := make([]byte, n)
+		io.ReadFull(r, bs)
+		var x map[string]interface{}
+		if err := json.Unmarshal(bs, &x); err != nil {
+			z.Fatal(err)
+		}
+		xs = append(xs, x)
+	}
+}
+
+func request(id int, method string, params interface{}) string {
+	bs, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params})
+	return string(bs)
+}
+
+func notify(method string, params interface{}) string {
+	bs, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+	return string(bs)
+}
+
+func open(text string) string {
+	return notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "twik", "version": 1, "text": text},
+	})
+}
+
+func at(id int, method string, line, char int) string {
+	return request(id, method, map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     map[string]int{"line": line, "character": char},
+	})
+}
+
+func toJSON(x interface{}) string {
+	bs, _ := json.Marshal(x)
+	return string(bs)
+}
+
+func TestServer(z *testing.T) {
+	code := "#include \"lib.twik\"\n(var x 1)\n(func f (a)\n(upper a))\n(f x)\n(if verbose (f \"ü\"))\n"
+	xs := session(z,
+		request(1, "initialize", map[string]interface{}{}),
+		notify("initialized", map[string]interface{}{}),
+		open(code),
+		at(2, "textDocument/hover", 3, 2),
+		at(3, "textDocument/hover", 5, 5),
+		at(4, "textDocument/completion", 3, 3),
+		at(5, "textDocument/definition", 4, 1),
+		at(6, "textDocument/definition", 0, 3),
+		request(7, "textDocument/formatting", map[string]interface{}{
+			"textDocument": map[string]string{"uri": uri},
+		}),
+		request(8, "shutdown", nil),
+		notify("exit", nil),
+	)
+	if len(xs) != 9 {
+		z.Fatalf("got %d messages; want 9: %v", len(xs), xs)
+	}
+
+	caps := toJSON(xs[0]["result"].(map[string]interface{})["capabilities"])
+	for _, c := range []string{"hoverProvider", "completionProvider", "definitionProvider", "documentFormattingProvider"} {
+		if !strings.Contains(caps, c) {
+			z.Errorf("capabilities %s lack %s", caps, c)
+		}
+	}
+
+	// The included file does not exist.
+	diags := toJSON(xs[1]["params"])
+	if !strings.Contains(diags, "lib.twik") {
+		z.Errorf("diagnostics %s do not mention lib.twik", diags)
+	}
+
+	tests := []struct {
+		msg  map[string]interface{}
+		want string
+	}{
+		{xs[2], `{"contents":{"kind":"plaintext","value":"upper :: s:string =\u003e string\n\nReturn s in upper case."},"range":{"end":{"character":6,"line":3},"start":{"character":1,"line":3}}}`},
+		{xs[3], `{"contents":{"kind":"plaintext","value":"verbose : bool\n\nPrint more."},"range":{"end":{"character":11,"line":5},"start":{"character":4,"line":5}}}`},
+		{xs[5], `{"range":{"end":{"character":7,"line":2},"start":{"character":6,"line":2}},"uri":"file:///project/main.twik"}`},
+		{xs[6], `{"range":{"end":{"character":0,"line":0},"start":{"character":0,"line":0}},"uri":"file:///project/lib.twik"}`},
+		{xs[7], `[{"newText":"#include \"lib.twik\"\n(var x 1)\n(func f (a)\n  (upper a))\n(f x)\n(if verbose (f \"ü\"))\n","range":{"end":{"character":0,"line":6},"start":{"character":0,"line":0}}}]`},
+		{xs[8], `null`},
+	}
+	for i, t := range tests {
+		if got := toJSON(t.msg["result"]); got != t.want {
+			z.Errorf("%d: result %s; want %s", i, got, t.want)
+		}
+	}
+
+	var labels []string
+	for _, item := range xs[4]["result"].([]interface{}) {
+		labels = append(labels, item.(map[string]interface{})["label"].(string))
+	}
+	if got := strings.Join(labels, " "); got != "upper" {
+		z.Errorf("completion = %s; want upper", got)
+	}
+}
+
+func TestDiagnostics(z *testing.T) {
+	xs := session(z,
+		open("(var x 1)\n(var y \"abc)\n"),
+		notify("textDocument/didChange", map[string]interface{}{
+			"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+			"contentChanges": []map[string]string{{"text": "(var x 1)\n"}},
+		}),
+	)
+	if len(xs) != 2 {
+		z.Fatalf("got %d messages; want 2", len(xs))
+	}
+	want := `{"diagnostics":[{"message":"unclosed string literal: \"abc)\n","range":{"end":{"character":8,"line":1},"start":{"character":7,"line":1}},"severity":1,"source":"twik"}],"uri":"file:///project/main.twik"}`
+	if got := toJSON(xs[0]["params"]); got != want {
+		z.Errorf("diagnostics %s; want %s", got, want)
+	}
+	if got := toJSON(xs[1]["params"]); got != `{"diagnostics":[],"uri":"file:///project/main.twik"}` {
+		z.Errorf("diagnostics after change %s; want none", got)
+	}
+}
+
+func TestMaxMessageSize(z *testing.T) {
+	s := lsp.NewServer(nil, nil)
+	s.MaxMessageSize = 64
+	xs := serve(z, s,
+		request(1, "textDocument/hover", map[string]string{"padding": strings.Repeat("x", 64)}),
+		request(2, "shutdown", nil),
+		notify("exit", nil),
+	)
+	if len(xs) != 2 {
+		z.Fatalf("got %d messages; want 2: %v", len(xs), xs)
+	}
+	if got := toJSON(xs[0]["error"]); !strings.Contains(got, "message too large") {
+		z.Errorf("error = %s; want message too large", got)
+	}
+	if xs[1]["id"] != float64(2) || xs[1]["error"] != nil {
+		z.Errorf("server did not continue after the large message: %v", xs[1])
+	}
+}
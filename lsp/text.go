@@ -0,0 +1,139 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package lsp
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/twik.v1"
+	"gopkg.in/twik.v1/ast"
+)
+
+// toOffset returns the byte offset in text of the position p, whose
+// character is counted in UTF-16 code units.
+func toOffset(text string, p position) int {
+	i := 0
+	for line := 0; line < p.Line; line++ {
+		j := strings.IndexByte(text[i:], '\n')
+		if j < 0 {
+			return len(text)
+		}
+		i += j + 1
+	}
+	for n := 0; n < p.Character && i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if r == '\n' {
+			break
+		}
+		n += runeLen(r)
+		i += size
+	}
+	return i
+}
+
+// toPosition returns the position of the byte offset in text.
+func toPosition(text string, offset int) position {
+	if offset > len(text) {
+		offset = len(text)
+	}
+	var p position
+	start := strings.LastIndexByte(text[:offset], '\n') + 1
+	p.Line = strings.Count(text[:start], "\n")
+	for _, r := range text[start:offset] {
+		p.Character += runeLen(r)
+	}
+	return p
+}
+
+// byteOffset returns the byte offset in text of line and column, both
+// counted from 1 as in error messages.
+func byteOffset(text string, line, col int) int {
+	i := 0
+	for ; line > 1; line-- {
+		j := strings.IndexByte(text[i:], '\n')
+		if j < 0 {
+			return len(text)
+		}
+		i += j + 1
+	}
+	if i += col - 1; i > len(text) {
+		i = len(text)
+	}
+	return i
+}
+
+// runeLen returns the number of UTF-16 code units of r.
+func runeLen(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// isDelim reports whether r ends a symbol.
+func isDelim(r rune) bool {
+	return r == '(' || r == ')' || r == '"' || r == ';' || unicode.IsSpace(r)
+}
+
+// wordAt returns the symbol around offset in text, with its start and end.
+func wordAt(text string, offset int) (word string, start, end int) {
+	start, end = offset, offset
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(text[:start])
+		if isDelim(r) {
+			break
+		}
+		start -= size
+	}
+	for end < len(text) {
+		r, size := utf8.DecodeRuneInString(text[end:])
+		if isDelim(r) {
+			break
+		}
+		end += size
+	}
+	return text[start:end], start, end
+}
+
+// binding is a variable or function defined with var or func.
+type binding struct {
+	name   string
+	offset int
+}
+
+// bindings returns the names bound by var and func forms in text, in the
+// order they appear. If text cannot be parsed, there are none.
+func bindings(text string) []binding {
+	node, err := twik.ParseString(twik.NewFileSet(), "", text)
+	if err != nil {
+		return nil
+	}
+	base := node.Pos()
+	var bs []binding
+	var walk func(n ast.Node)
+	walk = func(n ast.Node) {
+		var nodes []ast.Node
+		switch n := n.(type) {
+		case *ast.Root:
+			nodes = n.Nodes
+		case *ast.List:
+			nodes = n.Nodes
+			if len(nodes) > 1 {
+				fn, ok1 := nodes[0].(*ast.Symbol)
+				sym, ok2 := nodes[1].(*ast.Symbol)
+				if ok1 && ok2 && (fn.Name == "var" || fn.Name == "func") {
+					bs = append(bs, binding{sym.Name, int(sym.NamePos - base)})
+				}
+			}
+		}
+		for _, x := range nodes {
+			walk(x)
+		}
+	}
+	walk(node)
+	return bs
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil
+
+import (
+	"fmt"
+	"reflect"
+
+	"gopkg.in/twik.v1"
+)
+
+// ObjectFuncs returns a FuncMap with the exported methods of v as functions
+// named name.method, where method is the method name in lower-case words
+// separated by dashes. For a value repo with a method ListBranches, this
+// is repo.list-branches.
+//
+// The FuncMap also contains a dispatcher under name itself, which calls
+// a method by name: (repo "list-branches").
+//
+// If fields is true, each exported field of the struct v points to is
+// available with a getter name.field, and a setter name.set-field. Getters
+// and setters with the same name as a method are left out.
+//
+// The API of the object can be listed with FormatList.
+func ObjectFuncs(name string, v interface{}, fields bool) FuncMap {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		panic("ObjectFuncs: v must not be nil")
+	}
+
+	methods := make(FuncMap)
+	rt := rv.Type()
+	for i := 0; i < rt.NumMethod(); i++ {
+		m := rt.Method(i)
+		if m.PkgPath != "" {
+			continue
+		}
+		methods[kebabCase(m.Name)] = rv.Method(i).Interface()
+	}
+	if fields {
+		addFieldFuncs(methods, rv)
+	}
+
+	fm := methods.Prefixed(name + ".")
+	adapters := make(map[string]func([]interface{}) (interface{}, error), len(methods))
+	for k, f := range methods {
+		adapters[k] = Func(name+"."+k, f)
+	}
+	fm[name] = Fn(func(method string, args ...interface{}) (interface{}, error) {
+		f, ok := adapters[method]
+		if !ok {
+			return nil, fmt.Errorf("%s has no method %s", name, method)
+		}
+		return f(args)
+	}).Params("method", "args").Doc(fmt.Sprintf("Call a method of %s by name.", name))
+	return fm
+}
+
+func addFieldFuncs(fm FuncMap, rv reflect.Value) {
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return
+	}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := kebabCase(f.Name)
+		if _, ok := fm[name]; ok {
+			continue
+		}
+		fv := rv.Field(i)
+		getter := reflect.FuncOf(nil, []reflect.Type{f.Type}, false)
+		fm[name] = reflect.MakeFunc(getter, func(_ []reflect.Value) []reflect.Value {
+			return []reflect.Value{fv}
+		}).Interface()
+		if _, ok := fm["set-"+name]; ok || !fv.CanSet() {
+			continue
+		}
+		setter := reflect.FuncOf([]reflect.Type{f.Type}, nil, false)
+		fm["set-"+name] = reflect.MakeFunc(setter, func(in []reflect.Value) []reflect.Value {
+			fv.Set(in[0])
+			return nil
+		}).Interface()
+	}
+}
+
+// ExportObject exports the methods of v and a dispatcher to s, as
+// described by ObjectFuncs.
+func ExportObject(s *twik.Scope, name string, v interface{}) {
+	ObjectFuncs(name, v, false).Export(s)
+}
+
+// ExportObject exports the methods of v, and optionally its fields, as
+// described by ObjectFuncs. The names are registered with e as with Export.
+func (e *Executer) ExportObject(name string, v interface{}, fields bool) error {
+	return e.Export(ObjectFuncs(name, v, fields))
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/goulash/twikutil"
+)
+
+type repo struct {
+	Name     string
+	Branches int64
+	secret   string
+}
+
+func (r *repo) AddBranch(n int64) int64 { r.Branches += n; return r.Branches }
+func (r repo) Describe() string         { return r.Name + "!" }
+func (r *repo) hidden()                 {}
+
+func TestObject(z *testing.T) {
+	r := &repo{Name: "twik"}
+	fm := twikutil.ObjectFuncs("repo", r, true)
+	want := []string{
+		"repo :: method:string -> args:...{} => {}",
+		"repo.add-branch :: int64 => int64",
+		"repo.branches :: int64",
+		"repo.describe :: string",
+		"repo.name :: string",
+		"repo.set-branches :: int64 => ()",
+		"repo.set-name :: string => ()",
+	}
+	if api := fm.FormatList(); !reflect.DeepEqual(api, want) {
+		z.Errorf("API = %v; want %v", api, want)
+	}
+
+	e := twikutil.New(noFuncs)
+	if err := e.ExportObject("repo", r, true); err != nil {
+		z.Fatal(err)
+	}
+	code := `
+		(repo.set-name "goulash")
+		(repo.add-branch 2)
+		(repo "add-branch" 3)
+		(var d (repo.describe))
+		(var n (repo.branches))
+	`
+	if _, err := e.ExecString("test", code); err != nil {
+		z.Fatal(err)
+	}
+	if r.Branches != 5 || r.Name != "goulash" {
+		z.Errorf("repo = %+v", r)
+	}
+	if v, _ := e.Get("d"); v != "goulash!" {
+		z.Errorf("d = %v", v)
+	}
+	if v, _ := e.Get("n"); v != int64(5) {
+		z.Errorf("n = %v", v)
+	}
+	if _, err := e.ExecString("test", `(repo "hidden")`); err == nil || !strings.Contains(err.Error(), "repo has no method hidden") {
+		z.Errorf("unexpected error: %v", err)
+	}
+}
+
+type user struct {
+	Name string
+	Age  int64
+}
+
+func (u *user) SetName(name string) { u.Name = strings.ToUpper(name) }
+
+func TestObjectSetterConflict(z *testing.T) {
+	u := &user{}
+	fm := twikutil.ObjectFuncs("user", u, true)
+	want := []string{
+		"user :: method:string -> args:...{} => {}",
+		"user.age :: int64",
+		"user.name :: string",
+		"user.set-age :: int64 => ()",
+		"user.set-name :: string => ()",
+	}
+	if api := fm.FormatList(); !reflect.DeepEqual(api, want) {
+		z.Errorf("API = %v; want %v", api, want)
+	}
+
+	e := twikutil.New(noFuncs)
+	if err := e.ExportObject("user", u, true); err != nil {
+		z.Fatal(err)
+	}
+	if _, err := e.ExecString("test", `(user.set-name "ben") (user "set-age" 42)`); err != nil {
+		z.Fatal(err)
+	}
+	if u.Name != "BEN" || u.Age != 42 {
+		z.Errorf("user = %+v; want the method SetName to be called", u)
+	}
+}
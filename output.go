@@ -0,0 +1,84 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"gopkg.in/twik.v1"
+)
+
+// Stdout is the standard output of an Executer, which functions take as
+// an implicit parameter:
+//
+//  "say": func(w twikutil.Stdout, msg string) { fmt.Fprintln(w, msg) }
+type Stdout interface {
+	io.Writer
+}
+
+// Stderr is the standard error of an Executer, which functions take as an
+// implicit parameter to tell it apart from the standard output:
+//
+//  "warn": func(w twikutil.Stderr, msg string) { fmt.Fprintln(w, msg) }
+type Stderr interface {
+	io.Writer
+}
+
+// SetOutput sets the standard output and error that are passed to
+// functions of e, replacing os.Stdout and os.Stderr. If either is nil,
+// the one of the operating system is used. Forks of e inherit the output,
+// but output set on a fork does not apply to e.
+func (e *Executer) SetOutput(stdout, stderr io.Writer) {
+	e.stdout, e.stderr = stdout, stderr
+	e.redefine()
+}
+
+// Stdout returns the standard output of e.
+func (e *Executer) Stdout() io.Writer {
+	if e.stdout == nil {
+		return os.Stdout
+	}
+	return e.stdout
+}
+
+// Stderr returns the standard error of e.
+func (e *Executer) Stderr() io.Writer {
+	if e.stderr == nil {
+		return os.Stderr
+	}
+	return e.stderr
+}
+
+// ExecCapture executes file as Exec does and returns what it writes to
+// the standard output and error, interleaved as on a terminal. Afterwards,
+// the output of e is restored.
+func (e *Executer) ExecCapture(file string) (*twik.Scope, string, error) {
+	var s *twik.Scope
+	out, err := e.Capture(func(e *Executer) (err error) {
+		s, err = e.Exec(file)
+		return err
+	})
+	return s, out, err
+}
+
+// Capture calls f with e and returns what is written to the standard
+// output and error of e in the meantime, interleaved as on a terminal.
+// Afterwards, the output of e is restored. It captures the output of the
+// other ways to run scripts:
+//
+//  out, err := e.Capture(func(e *twikutil.Executer) error {
+//  	_, err := e.ExecString("main", code)
+//  	return err
+//  })
+func (e *Executer) Capture(f func(*Executer) error) (string, error) {
+	var buf bytes.Buffer
+	stdout, stderr := e.stdout, e.stderr
+	e.SetOutput(&buf, &buf)
+	defer e.SetOutput(stdout, stderr)
+	err := f(e)
+	return buf.String(), err
+}
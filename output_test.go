@@ -0,0 +1,141 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goulash/twikutil"
+	"gopkg.in/twik.v1"
+)
+
+func outputFuncs(s *twik.Scope) twikutil.FuncMap {
+	return twikutil.FuncMap{
+		"say": func(w twikutil.Stdout, xs ...interface{}) { fmt.Fprintln(w, xs...) },
+		"warn": func(w twikutil.Stderr, msg string) error {
+			_, err := fmt.Fprintln(w, "warning:", msg)
+			return err
+		},
+	}
+}
+
+func TestSetOutput(z *testing.T) {
+	var stdout, stderr bytes.Buffer
+	e := twikutil.New(outputFuncs)
+	e.SetOutput(&stdout, &stderr)
+	if _, err := e.ExecString("test", `(say "a" 1) (warn "b")`); err != nil {
+		z.Fatal(err)
+	}
+	if stdout.String() != "a 1\n" {
+		z.Errorf("stdout = %q; want %q", stdout.String(), "a 1\n")
+	}
+	if stderr.String() != "warning: b\n" {
+		z.Errorf("stderr = %q; want %q", stderr.String(), "warning: b\n")
+	}
+
+	var fout bytes.Buffer
+	f := e.Fork()
+	f.SetOutput(&fout, nil)
+	if _, err := f.ExecString("test", `(say "c")`); err != nil {
+		z.Fatal(err)
+	}
+	if fout.String() != "c\n" || stdout.String() != "a 1\n" {
+		z.Errorf("fork wrote %q, parent %q", fout.String(), stdout.String())
+	}
+	if f.Stderr() != os.Stderr {
+		z.Errorf("Stderr() of fork is not os.Stderr")
+	}
+}
+
+func TestExecCapture(z *testing.T) {
+	file := filepath.Join(z.TempDir(), "test.twik")
+	if err := os.WriteFile(file, []byte(`(say "out") (warn "err") (var x 1)`), 0644); err != nil {
+		z.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	e := twikutil.New(outputFuncs)
+	e.SetOutput(&stdout, nil)
+	s, out, err := e.ExecCapture(file)
+	if err != nil {
+		z.Fatal(err)
+	}
+	if want := "out\nwarning: err\n"; out != want {
+		z.Errorf("output = %q; want %q", out, want)
+	}
+	if x, _ := s.Get("x"); x != int64(1) {
+		z.Errorf("x = %v; want 1", x)
+	}
+	if _, err := e.ExecString("test", `(say "after")`); err != nil {
+		z.Fatal(err)
+	}
+	if stdout.String() != "after\n" {
+		z.Errorf("stdout after capture = %q; want %q", stdout.String(), "after\n")
+	}
+	if got := twikutil.Format("warn", outputFuncs(nil)["warn"]); got != "warn :: string => ()" {
+		z.Errorf("Format = %q", got)
+	}
+}
+
+func TestFuncStdout(z *testing.T) {
+	file := filepath.Join(z.TempDir(), "stdout")
+	f, err := os.Create(file)
+	if err != nil {
+		z.Fatal(err)
+	}
+	defer f.Close()
+
+	say := twikutil.Func("say", outputFuncs(nil)["say"])
+	stdout := os.Stdout
+	os.Stdout = f
+	_, err = say([]interface{}{"hello"})
+	os.Stdout = stdout
+	if err != nil {
+		z.Fatal(err)
+	}
+	if b, _ := os.ReadFile(file); string(b) != "hello\n" {
+		z.Errorf("say wrote %q to os.Stdout; want %q", b, "hello\n")
+	}
+}
+
+func TestCapture(z *testing.T) {
+	var stdout bytes.Buffer
+	e := twikutil.New(outputFuncs)
+	e.SetOutput(&stdout, nil)
+	p, err := e.Compile("prog", `(say "run")`)
+	if err != nil {
+		z.Fatal(err)
+	}
+	out, err := e.Capture(func(e *twikutil.Executer) error {
+		if _, err := e.ExecString("test", `(say "string") (warn "err")`); err != nil {
+			return err
+		}
+		_, err := e.Run(p)
+		return err
+	})
+	if err != nil {
+		z.Fatal(err)
+	}
+	if want := "string\nwarning: err\nrun\n"; out != want {
+		z.Errorf("output = %q; want %q", out, want)
+	}
+	if stdout.Len() != 0 {
+		z.Errorf("captured output was also written to stdout: %q", stdout.String())
+	}
+
+	out, err = e.Capture(func(e *twikutil.Executer) error {
+		_, err := e.ExecString("test", `(say "partial") (undefined)`)
+		return err
+	})
+	if err == nil || out != "partial\n" {
+		z.Errorf("Capture = %q, %v; want output and error", out, err)
+	}
+	if e.Stdout() != &stdout {
+		z.Error("output was not restored after failure")
+	}
+}
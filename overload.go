@@ -0,0 +1,118 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Overloads is a list of Go functions that are exported under a single
+// name. When called, the first function whose parameters accept the
+// arguments is called:
+//
+//  fm["len"] = twikutil.Overload(
+//      func(s string) int64 { return int64(len(s)) },
+//      func(xs []interface{}) int64 { return int64(len(xs)) },
+//  )
+//
+// The functions may also be a *Function.
+type Overloads []interface{}
+
+// Overload returns the functions fs as Overloads.
+func Overload(fs ...interface{}) Overloads {
+	return Overloads(fs)
+}
+
+func funcOverloaded(name string, fs Overloads, late func(error)) func([]interface{}) (interface{}, error) {
+	if len(fs) == 0 {
+		panic("Func: no overloads given")
+	}
+	adapters := make([]func([]interface{}) (interface{}, error), len(fs))
+	for i, f := range fs {
+		if _, ok := f.(Overloads); ok {
+			panic("Func: overloads cannot be nested")
+		}
+		adapters[i] = adapt(name, f, late)
+	}
+	return func(args []interface{}) (interface{}, error) {
+		cands := make([]int, 0, len(fs))
+		for i, f := range fs {
+			if paramCount(f, len(args)) {
+				cands = append(cands, i)
+			}
+		}
+		if len(cands) == 0 {
+			return nil, newParamError(name, fs)
+		}
+
+		// Narrow the candidates down argument by argument, so that we can
+		// report the first argument that no candidate accepts.
+		for i, arg := range args {
+			var want []string
+			accepted := cands[:0:0]
+			for _, c := range cands {
+				pt := paramType(fs[c], i, len(args))
+				if _, ok := argValue(nil, pt, arg); ok {
+					accepted = append(accepted, c)
+				} else {
+					want = appendUnique(want, typeName(pt))
+				}
+			}
+			if len(accepted) == 0 {
+				return nil, &TypeError{name, fs, arg, want}
+			}
+			cands = accepted
+		}
+		return adapters[cands[0]](args)
+	}
+}
+
+// paramCount reports whether f can be called with n arguments.
+func paramCount(f interface{}, n int) bool {
+	t := reflect.TypeOf(fnOf(f))
+	in := t.NumIn()
+	if fn, ok := f.(*Function); ok && fn.options != nil {
+		return n >= in-1 && (n-in+1)%2 == 0
+	}
+	if t.IsVariadic() {
+		return n >= in-1
+	}
+	return n == in
+}
+
+// paramType returns the type that the i-th of n arguments to f must have.
+func paramType(f interface{}, i, n int) reflect.Type {
+	t := reflect.TypeOf(fnOf(f))
+	in := t.NumIn()
+	if fn, ok := f.(*Function); ok && fn.options != nil && i >= in-1 {
+		if (i-in+1)%2 == 0 {
+			return reflect.TypeOf(Keyword(""))
+		}
+		return reflect.TypeOf((*interface{})(nil)).Elem()
+	}
+	if t.IsVariadic() && i >= in-1 {
+		return t.In(in - 1).Elem()
+	}
+	return t.In(i)
+}
+
+// formatOverloads formats each of the functions on a line of its own.
+func formatOverloads(name string, fs Overloads) string {
+	xs := make([]string, len(fs))
+	for i, f := range fs {
+		xs[i] = Format(name, f)
+	}
+	return strings.Join(xs, "\n\t")
+}
+
+func appendUnique(xs []string, s string) []string {
+	for _, x := range xs {
+		if x == s {
+			return xs
+		}
+	}
+	return append(xs, s)
+}
@@ -0,0 +1,345 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/twik.v1"
+)
+
+// Profile records how often functions are called and source lines are
+// evaluated by an Executer, and how long that takes.
+//
+// A profile is started with StartProfile and records until it is stopped.
+// It must not be used by Executers that run at the same time.
+type Profile struct {
+	e     *Executer
+	start time.Time
+	end   time.Time
+
+	mu      sync.Mutex
+	stack   []profileFrame
+	funcs   map[string]*ProfileEntry
+	lines   map[Position]*ProfileEntry
+	samples map[string]*profileSample
+}
+
+// ProfileEntry is the profile of a function or a source line.
+type ProfileEntry struct {
+	Name  string        // name of the function, or file:line
+	Calls int64         // number of times it was evaluated
+	Flat  time.Duration // time spent in it, excluding nested forms
+	Cum   time.Duration // time spent in it, including nested forms
+}
+
+type profileFrame struct {
+	form  *form
+	start time.Time
+	child time.Duration
+}
+
+type profileSample struct {
+	stack []*form // leaf first
+	calls int64
+	flat  time.Duration
+}
+
+// StartProfile starts profiling e and returns the profile.
+//
+// Time is attributed to each form that is evaluated: a form calling
+// a function counts towards the function and the line it starts on.
+// Time spent evaluating arguments and in the body of a function defined
+// in twik is counted as flat time of those forms. Positions are those of
+// the original files, if the PreProcessor included any.
+func (e *Executer) StartProfile() *Profile {
+	p := &Profile{
+		e:       e,
+		start:   time.Now(),
+		funcs:   make(map[string]*ProfileEntry),
+		lines:   make(map[Position]*ProfileEntry),
+		samples: make(map[string]*profileSample),
+	}
+	e.addProbe(p, p.probe)
+	return p
+}
+
+// Stop stops recording the profile.
+func (p *Profile) Stop() {
+	p.e.removeProbe(p)
+	p.end = time.Now()
+}
+
+func (p *Profile) probe(f *form, _ *twik.Scope, eval func() (interface{}, error)) (interface{}, error) {
+	p.mu.Lock()
+	p.stack = append(p.stack, profileFrame{form: f, start: time.Now()})
+	p.mu.Unlock()
+
+	v, err := eval()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := len(p.stack) - 1
+	fr := p.stack[n]
+	cum := time.Since(fr.start)
+	flat := cum - fr.child
+	p.stack = p.stack[:n]
+	if n > 0 {
+		p.stack[n-1].child += cum
+	}
+	p.record(f, flat, cum)
+	return v, err
+}
+
+func (p *Profile) record(f *form, flat, cum time.Duration) {
+	name := funcName(f)
+	line := Position{File: f.pos.File, Line: f.pos.Line}
+	recursive := func(key func(*form) bool) bool {
+		for _, fr := range p.stack {
+			if key(fr.form) {
+				return true
+			}
+		}
+		return false
+	}
+
+	fe := p.funcs[name]
+	if fe == nil {
+		fe = &ProfileEntry{Name: name}
+		p.funcs[name] = fe
+	}
+	fe.Calls++
+	fe.Flat += flat
+	if !recursive(func(g *form) bool { return funcName(g) == name }) {
+		fe.Cum += cum
+	}
+
+	le := p.lines[line]
+	if le == nil {
+		le = &ProfileEntry{Name: fmt.Sprintf("%s:%d", line.File, line.Line)}
+		p.lines[line] = le
+	}
+	le.Calls++
+	le.Flat += flat
+	if !recursive(func(g *form) bool { return g.pos.File == line.File && g.pos.Line == line.Line }) {
+		le.Cum += cum
+	}
+
+	var key strings.Builder
+	key.WriteString(strconv.Itoa(f.id))
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		key.WriteByte(' ')
+		key.WriteString(strconv.Itoa(p.stack[i].form.id))
+	}
+	s := p.samples[key.String()]
+	if s == nil {
+		s = &profileSample{stack: []*form{f}}
+		for i := len(p.stack) - 1; i >= 0; i-- {
+			s.stack = append(s.stack, p.stack[i].form)
+		}
+		p.samples[key.String()] = s
+	}
+	s.calls++
+	s.flat += flat
+}
+
+// funcName returns the name a form is profiled under.
+func funcName(f *form) string {
+	if f.name == "" {
+		return "(anonymous)"
+	}
+	return f.name
+}
+
+// Functions returns the profile of each function that was called, sorted
+// by flat time, longest first.
+func (p *Profile) Functions() []ProfileEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	xs := make([]ProfileEntry, 0, len(p.funcs))
+	for _, v := range p.funcs {
+		xs = append(xs, *v)
+	}
+	sortProfile(xs)
+	return xs
+}
+
+// Lines returns the profile of each line that was evaluated, sorted by
+// flat time, longest first.
+func (p *Profile) Lines() []ProfileEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	xs := make([]ProfileEntry, 0, len(p.lines))
+	for _, v := range p.lines {
+		xs = append(xs, *v)
+	}
+	sortProfile(xs)
+	return xs
+}
+
+func sortProfile(xs []ProfileEntry) {
+	sort.Slice(xs, func(i, j int) bool {
+		if xs[i].Flat != xs[j].Flat {
+			return xs[i].Flat > xs[j].Flat
+		}
+		return xs[i].Name < xs[j].Name
+	})
+}
+
+// WritePprof writes the profile to w in the format of pprof, so that it
+// can be examined with go tool pprof. Each form is a frame in the stack
+// traces, named after the function it calls.
+func (p *Profile) WritePprof(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var pb protobuf
+	strs := map[string]int64{"": 0}
+	strtab := []string{""}
+	str := func(s string) int64 {
+		if i, ok := strs[s]; ok {
+			return i
+		}
+		strs[s] = int64(len(strtab))
+		strtab = append(strtab, s)
+		return strs[s]
+	}
+	valueType := func(typ, unit string) []byte {
+		var vt protobuf
+		vt.int64(1, str(typ))
+		vt.int64(2, str(unit))
+		return vt.buf
+	}
+	pb.message(1, valueType("calls", "count"))
+	pb.message(1, valueType("time", "nanoseconds"))
+
+	keys := make([]string, 0, len(p.samples))
+	for k := range p.samples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	locs := make(map[int]*form)
+	for _, k := range keys {
+		s := p.samples[k]
+		ids := make([]uint64, len(s.stack))
+		for i, f := range s.stack {
+			ids[i] = uint64(f.id + 1)
+			locs[f.id] = f
+		}
+		var sp protobuf
+		sp.packed(1, ids)
+		sp.packed(2, []uint64{uint64(s.calls), uint64(s.flat)})
+		pb.message(2, sp.buf)
+	}
+
+	ids := make([]int, 0, len(locs))
+	for id := range locs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	type function struct{ name, file string }
+	funcs := make(map[function]uint64)
+	var fbufs [][]byte
+	for _, id := range ids {
+		f := locs[id]
+		fn := function{funcName(f), f.pos.File}
+		fid, ok := funcs[fn]
+		if !ok {
+			fid = uint64(len(funcs) + 1)
+			funcs[fn] = fid
+			var fp protobuf
+			fp.uint64(1, fid)
+			fp.int64(2, str(fn.name))
+			fp.int64(3, str(fn.name))
+			fp.int64(4, str(fn.file))
+			fbufs = append(fbufs, fp.buf)
+		}
+		var lp, ln protobuf
+		ln.uint64(1, fid)
+		ln.int64(2, int64(f.pos.Line))
+		lp.uint64(1, uint64(id+1))
+		lp.message(4, ln.buf)
+		pb.message(4, lp.buf)
+	}
+	for _, b := range fbufs {
+		pb.message(5, b)
+	}
+
+	end := p.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	// All strings must be in the table before it is written.
+	period := valueType("time", "nanoseconds")
+	for _, s := range strtab {
+		pb.string(6, s)
+	}
+	pb.int64(9, p.start.UnixNano())
+	pb.int64(10, int64(end.Sub(p.start)))
+	pb.message(11, period)
+	pb.int64(12, 1)
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(pb.buf); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// protobuf encodes the few parts of the protocol buffer wire format that
+// are needed for pprof profiles.
+type protobuf struct {
+	buf []byte
+}
+
+func (b *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		b.buf = append(b.buf, byte(x)|0x80)
+		x >>= 7
+	}
+	b.buf = append(b.buf, byte(x))
+}
+
+func (b *protobuf) tag(field, wire int) {
+	b.varint(uint64(field)<<3 | uint64(wire))
+}
+
+func (b *protobuf) uint64(field int, x uint64) {
+	b.tag(field, 0)
+	b.varint(x)
+}
+
+func (b *protobuf) int64(field int, x int64) {
+	b.uint64(field, uint64(x))
+}
+
+func (b *protobuf) bytes(field int, bs []byte) {
+	b.tag(field, 2)
+	b.varint(uint64(len(bs)))
+	b.buf = append(b.buf, bs...)
+}
+
+func (b *protobuf) string(field int, s string) {
+	b.bytes(field, []byte(s))
+}
+
+func (b *protobuf) message(field int, bs []byte) {
+	b.bytes(field, bs)
+}
+
+func (b *protobuf) packed(field int, xs []uint64) {
+	var p protobuf
+	for _, x := range xs {
+		p.varint(x)
+	}
+	b.bytes(field, p.buf)
+}
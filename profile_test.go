@@ -0,0 +1,89 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/goulash/pre"
+	"github.com/goulash/twikutil"
+	"gopkg.in/twik.v1"
+)
+
+func TestProfile(z *testing.T) {
+	fsys := fstest.MapFS{
+		"main.twik": {Data: []byte("#include \"lib.twik\"\n(range i 3\n  (upper (twice \"a\")))\n")},
+		"lib.twik":  {Data: []byte("; twice\n(func twice (s)\n  (upper s))\n")},
+	}
+	e := twikutil.New(func(*twik.Scope) twikutil.FuncMap {
+		return twikutil.FuncMap{"upper": strings.ToUpper}
+	})
+	e.PreProcessor = pre.New()
+	p := e.StartProfile()
+	if _, err := e.ExecFS(fsys, "main.twik"); err != nil {
+		z.Fatal(err)
+	}
+	p.Stop()
+
+	calls := make(map[string]int64)
+	for _, x := range p.Functions() {
+		calls[x.Name] = x.Calls
+		if x.Flat > x.Cum {
+			z.Errorf("%s: flat %v > cum %v", x.Name, x.Flat, x.Cum)
+		}
+	}
+	for _, x := range p.Lines() {
+		calls[x.Name] = x.Calls
+	}
+	want := map[string]int64{
+		"func":        1,
+		"range":       1,
+		"upper":       6,
+		"twice":       3,
+		"lib.twik:2":  1,
+		"lib.twik:3":  3,
+		"main.twik:2": 1,
+		"main.twik:3": 6,
+	}
+	for k, n := range want {
+		if calls[k] != n {
+			z.Errorf("%s: %d calls; want %d", k, calls[k], n)
+		}
+	}
+	if len(calls) != len(want) {
+		z.Errorf("unexpected entries: %v", calls)
+	}
+
+	// Forms that run after the profile is stopped are not recorded.
+	if _, err := e.ExecString("test", `(upper "b")`); err != nil {
+		z.Fatal(err)
+	}
+	if fs := p.Functions(); len(fs) != 4 {
+		z.Errorf("functions after stop: %v", fs)
+	}
+
+	var buf bytes.Buffer
+	if err := p.WritePprof(&buf); err != nil {
+		z.Fatal(err)
+	}
+	r, err := gzip.NewReader(&buf)
+	if err != nil {
+		z.Fatal(err)
+	}
+	bs, err := io.ReadAll(r)
+	if err != nil {
+		z.Fatal(err)
+	}
+	for _, s := range []string{"nanoseconds", "upper", "twice", "lib.twik"} {
+		if !bytes.Contains(bs, []byte(s)) {
+			z.Errorf("profile does not contain %q", s)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+
+	"github.com/goulash/pre"
+	past "github.com/goulash/pre/ast"
+
+	"gopkg.in/twik.v1"
+	"gopkg.in/twik.v1/ast"
+)
+
+// Program is a preprocessed and parsed script, which can be run many times
+// without being preprocessed and parsed again.
+type Program struct {
+	name string
+	code string    // preprocessed code
+	root past.Node // nil if not preprocessed
+	kws  []string  // keywords used in the code
+
+	mu    sync.Mutex
+	fset  *fileSet
+	node  ast.Node
+	nodes map[*fileSet]ast.Node
+
+	inodes map[*fileSet]instrumentedNode
+	syms   []string // symbols used in the code
+}
+
+// Name returns the name the program was compiled with.
+func (p *Program) Name() string { return p.name }
+
+// error converts an error from twik so that it refers to the original
+// file name, line, and column.
+func (p *Program) error(err error) error {
+	if err == nil || p.root == nil {
+		return err
+	}
+	return replaceError(p.name, p.root, err)
+}
+
+// nodeFor returns the parsed program for the file set fset.
+//
+// The positions in a parsed node are only meaningful in the file set that
+// the node was parsed into, which belongs to the Executer that compiled the
+// program and its forks. For other Executers, we parse the code once more.
+func (p *Program) nodeFor(fset *fileSet) (ast.Node, error) {
+	if fset == p.fset {
+		return p.node, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n, ok := p.nodes[fset]; ok {
+		return n, nil
+	}
+	n, err := fset.parseString(p.name, p.code, p.root)
+	if err != nil {
+		return nil, p.error(err)
+	}
+	if p.nodes == nil {
+		p.nodes = make(map[*fileSet]ast.Node)
+	}
+	p.nodes[fset] = n
+	return n, nil
+}
+
+// Compile preprocesses and parses code, so that it can be run repeatedly
+// with Run, by e as well as by any other Executer.
+//
+// Programs are cached by a hash of name and code and by the PreProcessor,
+// so compiling the same code again returns the same program. Only the
+// most recently used programs are kept. Files included by the PreProcessor
+// are not part of the hash; changes to them are not noticed.
+func (e *Executer) Compile(name, code string) (*Program, error) {
+	return e.cache.get(name, code, e.PreProcessor, func() (*Program, error) {
+		code, root, err := e.preprocess(name, code)
+		if err != nil {
+			return nil, err
+		}
+		return e.compile(name, code, root)
+	})
+}
+
+// compile parses code, which has been preprocessed into root if root
+// is not nil.
+func (e *Executer) compile(name, code string, root past.Node) (*Program, error) {
+	p := &Program{name: name, code: code, root: root, fset: e.fset}
+	node, err := e.fset.parseString(name, code, root)
+	if err != nil {
+		return nil, p.error(err)
+	}
+	p.node = node
+	p.kws = keywords(node)
+	return p, nil
+}
+
+// Run runs the program p in the scope of e.
+func (e *Executer) Run(p *Program) (*twik.Scope, error) {
+	return e.scope, e.run(p, e.scope)
+}
+
+func (e *Executer) run(p *Program, s *twik.Scope) error {
+	var node ast.Node
+	var err error
+	if len(e.probes) != 0 {
+		var forms []*form
+		node, forms, err = p.instrumented(e.fset, e.instr)
+		if err == nil {
+			e.track(forms, p)
+		}
+	} else {
+		node, err = p.nodeFor(e.fset)
+	}
+	if err != nil {
+		return err
+	}
+	defineKeywords(s, p.kws)
+	_, err = s.Eval(node)
+	return e.fset.error(err)
+}
+
+// maxPrograms is the number of programs that a program cache keeps.
+const maxPrograms = 256
+
+type programKey struct {
+	sum [sha256.Size]byte
+	pp  *pre.Processor
+}
+
+type programCache struct {
+	mu sync.Mutex
+	m  map[programKey]*list.Element
+	l  *list.List // most recently used first
+}
+
+type cachedProgram struct {
+	key programKey
+	p   *Program
+}
+
+func newProgramCache() *programCache {
+	return &programCache{m: make(map[programKey]*list.Element), l: list.New()}
+}
+
+func (c *programCache) get(name, code string, pp *pre.Processor, compile func() (*Program, error)) (*Program, error) {
+	key := programKey{sha256.Sum256([]byte(name + "\x00" + code)), pp}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.m[key]; ok {
+		c.l.MoveToFront(el)
+		return el.Value.(*cachedProgram).p, nil
+	}
+	p, err := compile()
+	if err != nil {
+		return nil, err
+	}
+	c.m[key] = c.l.PushFront(&cachedProgram{key, p})
+	if c.l.Len() > maxPrograms {
+		el := c.l.Back()
+		c.l.Remove(el)
+		delete(c.m, el.Value.(*cachedProgram).key)
+	}
+	return p, nil
+}
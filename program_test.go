@@ -0,0 +1,112 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/goulash/pre"
+	"github.com/goulash/twikutil"
+)
+
+const benchScript = `
+(var sum 0)
+(range i 100
+	(set sum (+ sum (* i input))))
+`
+
+func TestProgram(z *testing.T) {
+	e := twikutil.New(noFuncs)
+	e.PreProcessor = pre.New()
+	p, err := e.Compile("prog", "\n(var y (* x 2))\n(var z (+ y w))\n")
+	if err != nil {
+		z.Fatal(err)
+	}
+	if q, _ := e.Compile("prog", "\n(var y (* x 2))\n(var z (+ y w))\n"); q != p {
+		z.Error("Compile did not return the cached program")
+	}
+	f := e.Fork()
+	f.PreProcessor = pre.New()
+	if q, _ := f.Compile("prog", "\n(var y (* x 2))\n(var z (+ y w))\n"); q == p {
+		z.Error("Compile returned a program of another PreProcessor")
+	}
+
+	for i := int64(1); i <= 3; i++ {
+		f := e.Fork()
+		f.Set("x", i)
+		f.Set("w", int64(1))
+		if _, err := f.Run(p); err != nil {
+			z.Fatal(err)
+		}
+		if v, _ := f.Get("z"); v != 2*i+1 {
+			z.Errorf("z = %v; want %v", v, 2*i+1)
+		}
+	}
+	if e.Has("z") || e.Has("x") {
+		z.Error("forks modified their parent")
+	}
+
+	// Running in an unrelated Executer must still report correct positions.
+	o := twikutil.New(noFuncs)
+	o.Set("x", int64(1))
+	_, err = o.Run(p)
+	if err == nil || !strings.HasPrefix(err.Error(), "prog:3:") {
+		z.Errorf("unexpected error: %v", err)
+	}
+}
+
+func BenchmarkExecString(b *testing.B) {
+	e := twikutil.New(noFuncs)
+	e.PreProcessor = pre.New()
+	for i := 0; i < b.N; i++ {
+		f := e.Fork()
+		f.Set("input", int64(i))
+		if _, err := f.ExecString("bench", benchScript); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	e := twikutil.New(noFuncs)
+	e.PreProcessor = pre.New()
+	p, err := e.Compile("bench", benchScript)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f := e.Fork()
+		f.Set("input", int64(i))
+		if _, err := f.Run(p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestProgramErrors(z *testing.T) {
+	// Errors must refer to the right file, whichever was parsed last.
+	e := twikutil.New(noFuncs)
+	p1, err := e.Compile("p1", "(var a 1)\n(undefined-a)")
+	if err != nil {
+		z.Fatal(err)
+	}
+	if _, err := e.Compile("p2", "(undefined-b)"); err != nil {
+		z.Fatal(err)
+	}
+	_, err = e.Run(p1)
+	if err == nil || !strings.HasPrefix(err.Error(), "p1:2:2:") {
+		z.Errorf("unexpected error: %v", err)
+	}
+
+	if _, err := e.ExecString("f", "(func f (x)\n  (undefined x))"); err != nil {
+		z.Fatal(err)
+	}
+	_, err = e.ExecString("g", "(f 1)")
+	if err == nil || !strings.HasPrefix(err.Error(), "f:2:4:") {
+		z.Errorf("unexpected error: %v", err)
+	}
+}
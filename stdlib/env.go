@@ -0,0 +1,26 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package stdlib
+
+import "github.com/goulash/twikutil"
+
+// Env contains functions for the environment of the process:
+//
+//  (getenv name)    (lookup-env name)    (getwd)
+//
+// getenv returns "" for variables that are not set, and lookup-env nil.
+// The environment is that of the System of the Executer.
+var Env = twikutil.FuncMap{
+	"getenv": func(sys twikutil.Sys, name string) string {
+		v, _ := sys.LookupEnv(name)
+		return v
+	},
+	"lookup-env": func(sys twikutil.Sys, name string) (string, bool) {
+		return sys.LookupEnv(name)
+	},
+	"getwd": func(sys twikutil.Sys) (string, error) {
+		return sys.Getwd()
+	},
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package stdlib
+
+import (
+	"fmt"
+
+	"github.com/goulash/twikutil"
+)
+
+// Fmt contains functions for formatting values:
+//
+//  (sprintf format x ...)    (sprint x ...)
+//  (printf format x ...)     (print x ...)     (println x ...)
+//  (eprintf format x ...)    (eprintln x ...)
+//
+// The formats are those of the fmt package. printf, print, and println
+// write to the standard output of the Executer, and eprintf and eprintln
+// to its standard error.
+var Fmt = twikutil.FuncMap{
+	"sprintf": fmt.Sprintf,
+	"sprint":  fmt.Sprint,
+	"printf": func(w twikutil.Stdout, format string, xs ...interface{}) error {
+		_, err := fmt.Fprintf(w, format, xs...)
+		return err
+	},
+	"print": func(w twikutil.Stdout, xs ...interface{}) error {
+		_, err := fmt.Fprint(w, xs...)
+		return err
+	},
+	"println": func(w twikutil.Stdout, xs ...interface{}) error {
+		_, err := fmt.Fprintln(w, xs...)
+		return err
+	},
+	"eprintf": func(w twikutil.Stderr, format string, xs ...interface{}) error {
+		_, err := fmt.Fprintf(w, format, xs...)
+		return err
+	},
+	"eprintln": func(w twikutil.Stderr, xs ...interface{}) error {
+		_, err := fmt.Fprintln(w, xs...)
+		return err
+	},
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package stdlib
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+
+	"github.com/goulash/twikutil"
+)
+
+// Lists contains functions for working with lists:
+//
+//  (list x ...)         (len x)              (nth list i)
+//  (first list)         (last list)          (rest list)
+//  (append list x ...)  (concat list ...)    (reverse list)
+//  (slice list i j)     (sort list)          (member x list)
+//  (map f list)         (filter f list)      (reduce f x list)
+//  (sort-by less list)
+//
+// Functions never modify the list they are given, but return a new one.
+// The functions map, filter, reduce, and sort-by take a function, which
+// can be defined in twik with func.
+// The len function also accepts strings and maps. Lists given to sort must
+// contain only numbers or only strings. first and last return nil for an
+// empty list.
+var Lists = twikutil.FuncMap{
+	"list": func(xs ...interface{}) []interface{} {
+		if xs == nil {
+			return []interface{}{}
+		}
+		return xs
+	},
+	"len": twikutil.Overload(
+		func(s string) int64 { return int64(len(s)) },
+		func(xs []interface{}) int64 { return int64(len(xs)) },
+		func(m map[string]interface{}) int64 { return int64(len(m)) },
+	),
+	"nth": func(xs []interface{}, i int64) (interface{}, error) {
+		if i < 0 || i >= int64(len(xs)) {
+			return nil, errors.New("index out of range")
+		}
+		return xs[i], nil
+	},
+	"first": func(xs []interface{}) interface{} {
+		if len(xs) == 0 {
+			return nil
+		}
+		return xs[0]
+	},
+	"last": func(xs []interface{}) interface{} {
+		if len(xs) == 0 {
+			return nil
+		}
+		return xs[len(xs)-1]
+	},
+	"rest": func(xs []interface{}) []interface{} {
+		if len(xs) == 0 {
+			return []interface{}{}
+		}
+		return copyList(xs[1:])
+	},
+	"append": func(xs []interface{}, ys ...interface{}) []interface{} {
+		return append(copyList(xs), ys...)
+	},
+	"concat": func(xss ...[]interface{}) []interface{} {
+		var zs = []interface{}{}
+		for _, xs := range xss {
+			zs = append(zs, xs...)
+		}
+		return zs
+	},
+	"reverse": func(xs []interface{}) []interface{} {
+		ys := make([]interface{}, len(xs))
+		for i, x := range xs {
+			ys[len(xs)-1-i] = x
+		}
+		return ys
+	},
+	"slice": func(xs []interface{}, i, j int64) ([]interface{}, error) {
+		if i < 0 || j < i || j > int64(len(xs)) {
+			return nil, errors.New("slice out of range")
+		}
+		return copyList(xs[i:j]), nil
+	},
+	"sort": func(xs []interface{}) ([]interface{}, error) {
+		ys := copyList(xs)
+		var err error
+		sort.SliceStable(ys, func(i, j int) bool {
+			c, e := compare(ys[i], ys[j])
+			if e != nil && err == nil {
+				err = e
+			}
+			return c < 0
+		})
+		if err != nil {
+			return nil, err
+		}
+		return ys, nil
+	},
+	"map": func(f func(interface{}) (interface{}, error), xs []interface{}) ([]interface{}, error) {
+		ys := make([]interface{}, len(xs))
+		for i, x := range xs {
+			y, err := f(x)
+			if err != nil {
+				return nil, err
+			}
+			ys[i] = y
+		}
+		return ys, nil
+	},
+	"filter": func(f func(interface{}) (bool, error), xs []interface{}) ([]interface{}, error) {
+		ys := []interface{}{}
+		for _, x := range xs {
+			ok, err := f(x)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				ys = append(ys, x)
+			}
+		}
+		return ys, nil
+	},
+	"reduce": func(f func(acc, x interface{}) (interface{}, error), acc interface{}, xs []interface{}) (interface{}, error) {
+		for _, x := range xs {
+			var err error
+			if acc, err = f(acc, x); err != nil {
+				return nil, err
+			}
+		}
+		return acc, nil
+	},
+	"sort-by": func(less func(a, b interface{}) bool, xs []interface{}) []interface{} {
+		ys := copyList(xs)
+		sort.SliceStable(ys, func(i, j int) bool { return less(ys[i], ys[j]) })
+		return ys
+	},
+	"member": func(x interface{}, xs []interface{}) bool {
+		for _, y := range xs {
+			if reflect.DeepEqual(x, y) {
+				return true
+			}
+		}
+		return false
+	},
+}
+
+func copyList(xs []interface{}) []interface{} {
+	return append(make([]interface{}, 0, len(xs)), xs...)
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package stdlib
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/goulash/twikutil"
+)
+
+// Maps contains functions for working with maps from strings to values:
+//
+//  (dict key value ...)   (get m key)        (put m key value)
+//  (del m key)            (has-key m key)    (keys m)
+//  (values m)             (merge m ...)
+//
+// Functions never modify the map they are given, but return a new one.
+// get returns nil if the key is not in the map. keys returns the keys in
+// sorted order, and values returns the values in the same order.
+var Maps = twikutil.FuncMap{
+	"dict": func(kvs ...interface{}) (map[string]interface{}, error) {
+		if len(kvs)%2 != 0 {
+			return nil, errors.New("dict takes an even number of arguments")
+		}
+		m := make(map[string]interface{}, len(kvs)/2)
+		for i := 0; i < len(kvs); i += 2 {
+			k, ok := kvs[i].(string)
+			if !ok {
+				return nil, twikutil.NewTypeError(kvs[i], []string{"string"})
+			}
+			m[k] = kvs[i+1]
+		}
+		return m, nil
+	},
+	"get": func(m map[string]interface{}, k string) interface{} {
+		return m[k]
+	},
+	"put": func(m map[string]interface{}, k string, v interface{}) map[string]interface{} {
+		n := copyMap(m)
+		n[k] = v
+		return n
+	},
+	"del": func(m map[string]interface{}, k string) map[string]interface{} {
+		n := copyMap(m)
+		delete(n, k)
+		return n
+	},
+	"has-key": func(m map[string]interface{}, k string) bool {
+		_, ok := m[k]
+		return ok
+	},
+	"keys": func(m map[string]interface{}) []interface{} {
+		return twikutil.StringList(sortedKeys(m))
+	},
+	"values": func(m map[string]interface{}) []interface{} {
+		ks := sortedKeys(m)
+		xs := make([]interface{}, len(ks))
+		for i, k := range ks {
+			xs[i] = m[k]
+		}
+		return xs
+	},
+	"merge": func(ms ...map[string]interface{}) map[string]interface{} {
+		n := make(map[string]interface{})
+		for _, m := range ms {
+			for k, v := range m {
+				n[k] = v
+			}
+		}
+		return n
+	},
+}
+
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	n := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		n[k] = v
+	}
+	return n
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package stdlib
+
+import (
+	"errors"
+	"math"
+
+	"github.com/goulash/twikutil"
+)
+
+// Math contains numeric functions, complementing the arithmetic built
+// into twik:
+//
+//  (abs x)     (min x ...)    (max x ...)    (mod a b)
+//  (floor x)   (ceil x)       (round x)      (sqrt x)
+//  (pow x y)   (int x)        (float x)      (< a b)
+//  (<= a b)    (> a b)        (>= a b)       (random)
+//  (random-int n)
+//
+// Where it makes sense, functions accept both int64 and float64, and
+// return an int64 when all arguments are int64. The functions floor, ceil,
+// and round return float64; int truncates a float64 to int64. The
+// comparison functions compare two numbers or two strings. random returns
+// a float64 in [0, 1), and random-int an int64 in [0, n), both from the
+// System of the Executer.
+var Math = twikutil.FuncMap{
+	"abs": func(x interface{}) (interface{}, error) {
+		if i, ok := x.(int64); ok {
+			if i < 0 {
+				return -i, nil
+			}
+			return i, nil
+		}
+		f, err := toFloat(x)
+		return math.Abs(f), err
+	},
+	"min": func(x interface{}, xs ...interface{}) (interface{}, error) {
+		return extreme(x, xs, func(a, b float64) bool { return a < b })
+	},
+	"max": func(x interface{}, xs ...interface{}) (interface{}, error) {
+		return extreme(x, xs, func(a, b float64) bool { return a > b })
+	},
+	"mod": func(a, b int64) (int64, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a % b, nil
+	},
+	"floor": floatFn(math.Floor),
+	"ceil":  floatFn(math.Ceil),
+	"round": floatFn(math.Round),
+	"sqrt":  floatFn(math.Sqrt),
+	"pow": func(x, y interface{}) (float64, error) {
+		a, err := toFloat(x)
+		if err != nil {
+			return 0, err
+		}
+		b, err := toFloat(y)
+		return math.Pow(a, b), err
+	},
+	"int": func(x interface{}) (int64, error) {
+		if i, ok := x.(int64); ok {
+			return i, nil
+		}
+		f, err := toFloat(x)
+		return int64(f), err
+	},
+	"float": toFloat,
+	"<":     compareFn(func(c int) bool { return c < 0 }),
+	"<=":    compareFn(func(c int) bool { return c <= 0 }),
+	">":     compareFn(func(c int) bool { return c > 0 }),
+	">=":    compareFn(func(c int) bool { return c >= 0 }),
+	"random": func(sys twikutil.Sys) float64 {
+		return float64(sys.Int63()>>10) / (1 << 53)
+	},
+	"random-int": func(sys twikutil.Sys, n int64) (int64, error) {
+		if n <= 0 {
+			return 0, errors.New("n must be positive")
+		}
+		return sys.Int63() % n, nil
+	},
+}
+
+func floatFn(f func(float64) float64) func(interface{}) (float64, error) {
+	return func(x interface{}) (float64, error) {
+		v, err := toFloat(x)
+		return f(v), err
+	}
+}
+
+// compare compares two numbers or two strings.
+func compare(a, b interface{}) (int, error) {
+	if s, ok := a.(string); ok {
+		t, ok := b.(string)
+		if !ok {
+			return 0, twikutil.NewTypeError(b, []string{"string"})
+		}
+		return stringCompare(s, t), nil
+	}
+	if i, ok := a.(int64); ok {
+		if j, ok := b.(int64); ok {
+			return int64Compare(i, j), nil
+		}
+	}
+	x, err := toFloat(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := toFloat(b)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case x < y:
+		return -1, nil
+	case x > y:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+func compareFn(f func(int) bool) func(a, b interface{}) (bool, error) {
+	return func(a, b interface{}) (bool, error) {
+		c, err := compare(a, b)
+		return f(c), err
+	}
+}
+
+func stringCompare(a, b string) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func int64Compare(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func extreme(x interface{}, xs []interface{}, better func(a, b float64) bool) (interface{}, error) {
+	best := x
+	bf, err := toFloat(x)
+	if err != nil {
+		return nil, err
+	}
+	for _, y := range xs {
+		yf, err := toFloat(y)
+		if err != nil {
+			return nil, err
+		}
+		if better(yf, bf) {
+			best, bf = y, yf
+		}
+	}
+	return best, nil
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package stdlib
+
+import (
+	"path/filepath"
+
+	"github.com/goulash/twikutil"
+)
+
+// Path contains functions for manipulating file paths, using the
+// path/filepath package:
+//
+//  (path-join elem ...)    (path-base p)    (path-dir p)
+//  (path-ext p)            (path-clean p)   (path-abs p)
+//  (path-match pattern p)
+//
+// Relative paths are made absolute with the working directory of the
+// System of the Executer.
+var Path = twikutil.FuncMap{
+	"path-join":  filepath.Join,
+	"path-base":  filepath.Base,
+	"path-dir":   filepath.Dir,
+	"path-ext":   filepath.Ext,
+	"path-clean": filepath.Clean,
+	"path-abs":   pathAbs,
+	"path-match": filepath.Match,
+}
+
+func pathAbs(sys twikutil.Sys, p string) (string, error) {
+	if filepath.IsAbs(p) {
+		return filepath.Clean(p), nil
+	}
+	wd, err := sys.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(wd, p), nil
+}
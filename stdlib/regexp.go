@@ -0,0 +1,93 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package stdlib
+
+import (
+	"regexp"
+	"sync"
+
+	"github.com/goulash/twikutil"
+)
+
+// Regexp contains functions for regular expressions, using the syntax
+// of the regexp package:
+//
+//  (match pattern s)              (re-find pattern s)
+//  (re-find-all pattern s)        (re-replace pattern s repl)
+//  (re-split pattern s)
+//
+// re-find returns nil if there is no match. In re-replace, $1 in repl
+// refers to the first submatch. Up to 64 compiled patterns are cached.
+var Regexp = twikutil.FuncMap{
+	"match": func(pattern, s string) (bool, error) {
+		re, err := compileRegexp(pattern)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(s), nil
+	},
+	"re-find": func(pattern, s string) (interface{}, error) {
+		re, err := compileRegexp(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if loc := re.FindStringIndex(s); loc != nil {
+			return s[loc[0]:loc[1]], nil
+		}
+		return nil, nil
+	},
+	"re-find-all": func(pattern, s string) ([]interface{}, error) {
+		re, err := compileRegexp(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return twikutil.StringList(re.FindAllString(s, -1)), nil
+	},
+	"re-replace": func(pattern, s, repl string) (string, error) {
+		re, err := compileRegexp(pattern)
+		if err != nil {
+			return "", err
+		}
+		return re.ReplaceAllString(s, repl), nil
+	},
+	"re-split": func(pattern, s string) ([]interface{}, error) {
+		re, err := compileRegexp(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return twikutil.StringList(re.Split(s, -1)), nil
+	},
+}
+
+// maxRegexps is the number of compiled patterns that are cached.
+const maxRegexps = 64
+
+var regexpCache = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: make(map[string]*regexp.Regexp)}
+
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	regexpCache.Lock()
+	re, ok := regexpCache.m[pattern]
+	regexpCache.Unlock()
+	if ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexpCache.Lock()
+	defer regexpCache.Unlock()
+	if len(regexpCache.m) >= maxRegexps {
+		for k := range regexpCache.m {
+			delete(regexpCache.m, k)
+			break
+		}
+	}
+	regexpCache.m[pattern] = re
+	return re, nil
+}
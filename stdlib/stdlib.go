@@ -0,0 +1,69 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+// Package stdlib provides ready-made function maps for twik scripts.
+//
+// Each bundle is a twikutil.FuncMap that can be merged into the function
+// map returned by a twikutil.LoaderFunc:
+//
+//  func loader(s *twik.Scope) twikutil.FuncMap {
+//      fm := make(twikutil.FuncMap)
+//      fm.Import(stdlib.Strings)
+//      fm.Import(stdlib.Lists)
+//      return fm
+//  }
+//
+// The functions are designed for the values twik works with: integers are
+// int64, floats are float64, and lists are []interface{}. Maps are
+// map[string]interface{}, as created by dict. The names of the functions
+// do not collide between bundles, so All contains every bundle.
+package stdlib
+
+import (
+	"fmt"
+
+	"github.com/goulash/twikutil"
+)
+
+// All contains all the bundles in this package.
+var All = join(Strings, Math, Lists, Maps, Fmt, Regexp, Time, Path, Env)
+
+// join merges the bundles fms. It panics if any of them share a name, so
+// that a bundle cannot silently replace a function of another.
+func join(fms ...twikutil.FuncMap) twikutil.FuncMap {
+	all := make(twikutil.FuncMap)
+	for _, fm := range fms {
+		if err := all.ImportStrict(fm); err != nil {
+			panic("stdlib: " + err.Error())
+		}
+	}
+	return all
+}
+
+var numberTypes = []string{"int64", "float64"}
+
+// toFloat converts an int64 or float64 to float64.
+func toFloat(x interface{}) (float64, error) {
+	switch v := x.(type) {
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	default:
+		return 0, twikutil.NewTypeError(x, numberTypes)
+	}
+}
+
+func toStrings(xs []interface{}) ([]string, error) {
+	ss := make([]string, len(xs))
+	for i, x := range xs {
+		s, ok := x.(string)
+		if !ok {
+			return nil, fmt.Errorf("element %d is not a string: %v", i, x)
+		}
+		ss[i] = s
+	}
+	return ss, nil
+}
+
@@ -0,0 +1,247 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package stdlib_test
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/goulash/twikutil"
+	"github.com/goulash/twikutil/stdlib"
+	"gopkg.in/twik.v1"
+)
+
+func loader(_ *twik.Scope) twikutil.FuncMap { return stdlib.All }
+
+type evalTest struct {
+	Code  string
+	Value interface{}
+}
+
+type list = []interface{}
+type dict = map[string]interface{}
+
+func TestStdlib(z *testing.T) {
+	tests := []evalTest{
+		// strings
+		{`(upper "abc")`, "ABC"},
+		{`(trim-suffix "a.twik" ".twik")`, "a"},
+		{`(has-prefix "twik" "tw")`, true},
+		{`(index "twik" "ik")`, int64(2)},
+		{`(index "twik" "x")`, int64(-1)},
+		{`(replace "a-b-c" "-" "+")`, "a+b+c"},
+		{`(split "a,b" ",")`, list{"a", "b"}},
+		{`(join (fields " a  b ") "-")`, "a-b"},
+		{`(repeat "ab" 3)`, "ababab"},
+		{`(substr "hello" 1 3)`, "el"},
+		{`(parse-int "0x10")`, int64(16)},
+
+		// math
+		{`(abs -3)`, int64(3)},
+		{`(abs -2.5)`, 2.5},
+		{`(min 3 1 2)`, int64(1)},
+		{`(max 1 2.5 2)`, 2.5},
+		{`(mod 7 3)`, int64(1)},
+		{`(floor 2.7)`, 2.0},
+		{`(sqrt 16)`, 4.0},
+		{`(pow 2 10)`, 1024.0},
+		{`(int 2.9)`, int64(2)},
+		{`(float 2)`, 2.0},
+		{`(< 1 2)`, true},
+		{`(>= 1 2.5)`, false},
+		{`(< "a" "b")`, true},
+
+		// lists
+		{`(list)`, list{}},
+		{`(list 1 "a")`, list{int64(1), "a"}},
+		{`(len (list 1 2 3))`, int64(3)},
+		{`(len "twik")`, int64(4)},
+		{`(nth (list 1 2 3) 1)`, int64(2)},
+		{`(first (list))`, nil},
+		{`(last (list 1 2))`, int64(2)},
+		{`(rest (list 1 2 3))`, list{int64(2), int64(3)}},
+		{`(append (list 1) 2 3)`, list{int64(1), int64(2), int64(3)}},
+		{`(concat (list 1) (list) (list 2))`, list{int64(1), int64(2)}},
+		{`(reverse (list 1 2))`, list{int64(2), int64(1)}},
+		{`(slice (list 1 2 3) 1 2)`, list{int64(2)}},
+		{`(sort (list 3 1.5 2))`, list{1.5, int64(2), int64(3)}},
+		{`(sort (list "b" "a"))`, list{"a", "b"}},
+		{`(member 2 (list 1 2))`, true},
+		{`(member (list 2) (list 1 (list 2)))`, true},
+		{`(member (dict "a" 1) (list (list 1)))`, false},
+		{`(map (func (x) (+ x 1)) (list 1 2))`, list{int64(2), int64(3)}},
+		{`(filter (func (x) (> x 1)) (list 1 2 3))`, list{int64(2), int64(3)}},
+		{`(filter (func (x) false) (list 1))`, list{}},
+		{`(reduce + 0 (list 1 2 3))`, int64(6)},
+		{`(sort-by (func (a b) (> (len a) (len b))) (list "a" "abc" "ab"))`, list{"abc", "ab", "a"}},
+
+		// maps
+		{`(dict "a" 1)`, dict{"a": int64(1)}},
+		{`(get (dict "a" 1) "a")`, int64(1)},
+		{`(get (dict "a" 1) "b")`, nil},
+		{`(put (dict "a" 1) "b" 2)`, dict{"a": int64(1), "b": int64(2)}},
+		{`(del (dict "a" 1) "a")`, dict{}},
+		{`(has-key (dict "a" 1) "a")`, true},
+		{`(keys (dict "b" 1 "a" 2))`, list{"a", "b"}},
+		{`(values (dict "b" 1 "a" 2))`, list{int64(2), int64(1)}},
+		{`(len (merge (dict "a" 1) (dict "b" 2)))`, int64(2)},
+
+		// fmt
+		{`(sprintf "%s=%d" "a" 1)`, "a=1"},
+		{`(sprint "a" 1)`, "a1"},
+
+		// regexp
+		{`(match "^t.*k$" "twik")`, true},
+		{`(re-find "[0-9]+" "ab12cd34")`, "12"},
+		{`(re-find "[0-9]+" "abcd")`, nil},
+		{`(re-find-all "[0-9]+" "ab12cd34")`, list{"12", "34"}},
+		{`(re-replace "(a)(b)" "abab" "$2$1")`, "baba"},
+		{`(re-split "[,;]" "a,b;c")`, list{"a", "b", "c"}},
+
+		// time
+		{`(format-time (parse-time "2006-01-02" "2015-03-04") "02.01.2006")`, "04.03.2015"},
+		{`(seconds (parse-duration "1m30s"))`, 90.0},
+		{`(unix (add-duration (parse-time "2006-01-02" "1970-01-01") (parse-duration "1h")))`, int64(3600)},
+
+		// path
+		{`(path-join "a" "b" "c.twik")`, filepath.Join("a", "b", "c.twik")},
+		{`(path-ext "a/b.twik")`, ".twik"},
+		{`(path-match "*.twik" "b.twik")`, true},
+	}
+
+	for _, t := range tests {
+		v, err := eval(twikutil.New(loader), t.Code)
+		if err != nil {
+			z.Errorf("%s: unexpected error: %v", t.Code, err)
+		} else if !reflect.DeepEqual(v, t.Value) {
+			z.Errorf("%s = %#v; want %#v", t.Code, v, t.Value)
+		}
+	}
+}
+
+func TestStdlibErrors(z *testing.T) {
+	tests := []string{
+		`(abs "a")`,
+		`(nth (list 1) 1)`,
+		`(join (list 1) ",")`,
+		`(dict "a")`,
+		`(match "(" "a")`,
+		`(parse-int "a")`,
+		`(sort (list 1 "a"))`,
+		`(map (func (x y) x) (list 1))`,
+		`(filter (func (x) 1) (list 1))`,
+		`(sort-by (func (a b) (nth a 1)) (list 1 2))`,
+		`(repeat "ab" -1)`,
+		`(repeat "ab" 9223372036854775807)`,
+	}
+	for _, code := range tests {
+		if _, err := eval(twikutil.New(loader), code); err == nil {
+			z.Errorf("%s: expected error", code)
+		}
+	}
+}
+
+func TestNow(z *testing.T) {
+	v, err := eval(twikutil.New(loader), `(now)`)
+	if err != nil {
+		z.Fatal(err)
+	}
+	if t, ok := v.(time.Time); !ok || time.Since(t) > time.Minute {
+		z.Errorf("(now) = %v", v)
+	}
+}
+
+func TestSystem(z *testing.T) {
+	start := time.Date(2015, 3, 4, 0, 0, 0, 0, time.UTC)
+	newExecuter := func() *twikutil.Executer {
+		e := twikutil.New(loader)
+		e.SetSystem(&twikutil.Fixed{
+			Time: start,
+			Step: time.Second,
+			Seed: 1,
+			Env:  map[string]string{"HOME": "/home/twik"},
+			Dir:  "/work",
+		})
+		return e
+	}
+	tests := []evalTest{
+		{`(now)`, start},
+		{`(seconds (since (now)))`, 1.0},
+		{`(getenv "HOME")`, "/home/twik"},
+		{`(getenv "PATH")`, ""},
+		{`(lookup-env "PATH")`, nil},
+		{`(getwd)`, "/work"},
+		{`(path-abs "a.twik")`, filepath.Join("/work", "a.twik")},
+		{`(< (random-int 10) 10)`, true},
+		{`(< (random) 1)`, true},
+	}
+	for _, t := range tests {
+		v, err := eval(newExecuter(), t.Code)
+		if err != nil {
+			z.Errorf("%s: unexpected error: %v", t.Code, err)
+		} else if !reflect.DeepEqual(v, t.Value) {
+			z.Errorf("%s = %#v; want %#v", t.Code, v, t.Value)
+		}
+	}
+
+	a, _ := eval(newExecuter(), `(list (random-int 1000) (random-int 1000))`)
+	b, _ := eval(newExecuter(), `(list (random-int 1000) (random-int 1000))`)
+	if !reflect.DeepEqual(a, b) {
+		z.Errorf("random numbers differ with the same seed: %v and %v", a, b)
+	}
+}
+
+func TestPrint(z *testing.T) {
+	var stdout, stderr bytes.Buffer
+	e := twikutil.New(loader)
+	e.SetOutput(&stdout, &stderr)
+	code := `(printf "%s=%d\n" "a" 1) (print "b" 2) (println "c") (eprintf "%d" 3) (eprintln "d")`
+	if _, err := e.ExecString("test", code); err != nil {
+		z.Fatal(err)
+	}
+	if want := "a=1\nb2c\n"; stdout.String() != want {
+		z.Errorf("stdout = %q; want %q", stdout.String(), want)
+	}
+	if want := "3d\n"; stderr.String() != want {
+		z.Errorf("stderr = %q; want %q", stderr.String(), want)
+	}
+}
+
+// eval returns the value of the last expression in code.
+func eval(e *twikutil.Executer, code string) (interface{}, error) {
+	if _, err := e.ExecString("test", "(var result "+code+")"); err != nil {
+		return nil, err
+	}
+	return e.Get("result")
+}
+
+func TestAll(z *testing.T) {
+	bundles := map[string]twikutil.FuncMap{
+		"Strings": stdlib.Strings,
+		"Math":    stdlib.Math,
+		"Lists":   stdlib.Lists,
+		"Maps":    stdlib.Maps,
+		"Fmt":     stdlib.Fmt,
+		"Regexp":  stdlib.Regexp,
+		"Time":    stdlib.Time,
+		"Path":    stdlib.Path,
+		"Env":     stdlib.Env,
+	}
+	owner := make(map[string]string)
+	for b, fm := range bundles {
+		for name := range fm {
+			if o, ok := owner[name]; ok {
+				z.Errorf("%s is in both %s and %s", name, o, b)
+			}
+			owner[name] = b
+		}
+	}
+	if len(stdlib.All) != len(owner) {
+		z.Errorf("All has %d functions; want %d", len(stdlib.All), len(owner))
+	}
+}
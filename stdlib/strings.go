@@ -0,0 +1,81 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package stdlib
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/goulash/twikutil"
+)
+
+// maxStringLen is the length in bytes of the longest string that repeat
+// returns.
+const maxStringLen = 16 << 20
+
+// Strings contains functions for working with strings:
+//
+//  (upper s)              (lower s)             (trim s)
+//  (trim-prefix s p)      (trim-suffix s p)     (has-prefix s p)
+//  (has-suffix s p)       (contains s sub)      (index s sub)
+//  (replace s old new)    (split s sep)         (fields s)
+//  (join list sep)        (repeat s n)          (substr s i j)
+//  (parse-int s)          (parse-float s)       (parse-bool s)
+//
+// Indexes are byte offsets, index returns -1 if sub is not in s, and
+// join requires all elements of list to be strings. repeat fails if the
+// result would be longer than 16 MiB.
+var Strings = twikutil.FuncMap{
+	"upper":       strings.ToUpper,
+	"lower":       strings.ToLower,
+	"trim":        strings.TrimSpace,
+	"trim-prefix": strings.TrimPrefix,
+	"trim-suffix": strings.TrimSuffix,
+	"has-prefix":  strings.HasPrefix,
+	"has-suffix":  strings.HasSuffix,
+	"contains":    strings.Contains,
+	"index": func(s, sub string) int64 {
+		return int64(strings.Index(s, sub))
+	},
+	"replace": func(s, old, new string) string {
+		return strings.Replace(s, old, new, -1)
+	},
+	"split": func(s, sep string) []interface{} {
+		return twikutil.StringList(strings.Split(s, sep))
+	},
+	"fields": func(s string) []interface{} {
+		return twikutil.StringList(strings.Fields(s))
+	},
+	"join": func(xs []interface{}, sep string) (string, error) {
+		ss, err := toStrings(xs)
+		if err != nil {
+			return "", err
+		}
+		return strings.Join(ss, sep), nil
+	},
+	"repeat": func(s string, n int64) (string, error) {
+		if n < 0 {
+			return "", errors.New("negative repeat count")
+		}
+		if n > 0 && int64(len(s)) > maxStringLen/n {
+			return "", errors.New("repeat result too long")
+		}
+		return strings.Repeat(s, int(n)), nil
+	},
+	"substr": func(s string, i, j int64) (string, error) {
+		if i < 0 || j < i || j > int64(len(s)) {
+			return "", errors.New("substring out of range")
+		}
+		return s[i:j], nil
+	},
+	"parse-int": func(s string) (int64, error) {
+		return strconv.ParseInt(s, 0, 64)
+	},
+	"parse-float": func(s string) (float64, error) {
+		return strconv.ParseFloat(s, 64)
+	},
+	"parse-bool": strconv.ParseBool,
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package stdlib
+
+import (
+	"time"
+
+	"github.com/goulash/twikutil"
+)
+
+// Time contains functions for times and durations:
+//
+//  (now)                     (unix t)               (since t)
+//  (format-time t layout)    (parse-time layout s)  (parse-duration s)
+//  (add-duration t d)        (seconds d)
+//
+// Layouts are those of the time package, such as "2006-01-02". Durations
+// are parsed from strings such as "1h30m", and seconds converts a duration
+// to a float64 number of seconds. The current time is that of the System of
+// the Executer.
+var Time = twikutil.FuncMap{
+	"now":            func(sys twikutil.Sys) time.Time { return sys.Now() },
+	"unix":           func(t time.Time) int64 { return t.Unix() },
+	"since":          func(sys twikutil.Sys, t time.Time) time.Duration { return sys.Now().Sub(t) },
+	"format-time":    func(t time.Time, layout string) string { return t.Format(layout) },
+	"parse-time":     time.Parse,
+	"parse-duration": time.ParseDuration,
+	"add-duration":   func(t time.Time, d time.Duration) time.Time { return t.Add(d) },
+	"seconds":        func(d time.Duration) float64 { return d.Seconds() },
+}
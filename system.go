@@ -0,0 +1,278 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// System provides the parts of the environment that scripts can observe:
+// the clock, random numbers, environment variables, and the working
+// directory. Functions take the System of the Executer as an implicit
+// first parameter of type Sys, so that scripts that use them can be run
+// deterministically:
+//
+//  fm["getenv"] = func(sys twikutil.Sys, key string) string {
+//      v, _ := sys.LookupEnv(key)
+//      return v
+//  }
+//
+// Use SetSystem to replace the System of an Executer.
+type System interface {
+	Now() time.Time
+	Int63() int64 // non-negative pseudo-random number
+	LookupEnv(key string) (string, bool)
+	Getwd() (string, error)
+}
+
+// Sys is the System of an Executer, which functions take as an implicit
+// parameter. Parameters of type System are passed by scripts as usual.
+type Sys interface {
+	System
+}
+
+// OS is the System of the operating system, which Executers use unless
+// another one is set.
+var OS System = osSystem{}
+
+type osSystem struct{}
+
+func (osSystem) Now() time.Time                      { return time.Now() }
+func (osSystem) Int63() int64                        { return rand.Int63() }
+func (osSystem) LookupEnv(key string) (string, bool) { return os.LookupEnv(key) }
+func (osSystem) Getwd() (string, error)              { return os.Getwd() }
+
+// SetSystem sets the System that is passed to functions of e, replacing
+// OS. Forks of e inherit the System, but one set on a fork does not apply
+// to e.
+func (e *Executer) SetSystem(sys System) {
+	e.sys = sys
+	e.redefine()
+}
+
+// System returns the System of e.
+func (e *Executer) System() System {
+	if e.sys == nil {
+		return OS
+	}
+	return e.sys
+}
+
+// Fixed is a System that does not depend on the environment. Its clock
+// starts at Time and advances by Step each time it is read, so that
+// durations are never zero unless Step is. Random numbers come from
+// a source seeded with Seed.
+type Fixed struct {
+	Time time.Time
+	Step time.Duration
+	Seed int64
+	Env  map[string]string
+	Dir  string // working directory, or "" if there is none
+
+	mu  sync.Mutex
+	n   int
+	rng *rand.Rand
+}
+
+func (f *Fixed) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := f.Time.Add(time.Duration(f.n) * f.Step)
+	f.n++
+	return t
+}
+
+func (f *Fixed) Int63() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.rng == nil {
+		f.rng = rand.New(rand.NewSource(f.Seed))
+	}
+	return f.rng.Int63()
+}
+
+func (f *Fixed) LookupEnv(key string) (string, bool) {
+	v, ok := f.Env[key]
+	return v, ok
+}
+
+var errNoDir = errors.New("no working directory")
+
+func (f *Fixed) Getwd() (string, error) {
+	if f.Dir == "" {
+		return "", errNoDir
+	}
+	return f.Dir, nil
+}
+
+// Recording contains the results that a System returned, in the order
+// they were returned, so that a run can be replayed. Environment variables
+// that were not set are recorded as nil.
+type Recording struct {
+	Times []time.Time        `json:"times,omitempty"`
+	Rand  []int64            `json:"rand,omitempty"`
+	Env   map[string]*string `json:"env,omitempty"`
+	Dir   string             `json:"dir,omitempty"`
+}
+
+// Recorder is a System that records the results of another System.
+type Recorder struct {
+	sys System
+
+	mu  sync.Mutex
+	rec Recording
+}
+
+// Record returns a Recorder for sys.
+func Record(sys System) *Recorder {
+	return &Recorder{sys: sys}
+}
+
+func (r *Recorder) Now() time.Time {
+	t := r.sys.Now()
+	r.mu.Lock()
+	r.rec.Times = append(r.rec.Times, t)
+	r.mu.Unlock()
+	return t
+}
+
+func (r *Recorder) Int63() int64 {
+	x := r.sys.Int63()
+	r.mu.Lock()
+	r.rec.Rand = append(r.rec.Rand, x)
+	r.mu.Unlock()
+	return x
+}
+
+func (r *Recorder) LookupEnv(key string) (string, bool) {
+	v, ok := r.sys.LookupEnv(key)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rec.Env == nil {
+		r.rec.Env = make(map[string]*string)
+	}
+	if ok {
+		r.rec.Env[key] = &v
+	} else {
+		r.rec.Env[key] = nil
+	}
+	return v, ok
+}
+
+func (r *Recorder) Getwd() (string, error) {
+	dir, err := r.sys.Getwd()
+	if err == nil {
+		r.mu.Lock()
+		r.rec.Dir = dir
+		r.mu.Unlock()
+	}
+	return dir, err
+}
+
+// Recording returns a copy of what has been recorded so far.
+func (r *Recorder) Recording() *Recording {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec := &Recording{
+		Times: append([]time.Time(nil), r.rec.Times...),
+		Rand:  append([]int64(nil), r.rec.Rand...),
+		Dir:   r.rec.Dir,
+	}
+	if r.rec.Env != nil {
+		rec.Env = make(map[string]*string, len(r.rec.Env))
+		for k, v := range r.rec.Env {
+			rec.Env[k] = v
+		}
+	}
+	return rec
+}
+
+// ReadRecording reads a recording in the format written by Write.
+func ReadRecording(r io.Reader) (*Recording, error) {
+	var rec Recording
+	if err := json.NewDecoder(r).Decode(&rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// Write writes the recording to w as JSON.
+func (rec *Recording) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rec)
+}
+
+// Replay returns a System that returns the recorded results in order.
+// Once the recorded times or random numbers run out, and for environment
+// variables that were not looked up and a working directory that was not
+// recorded, fallback is asked instead. If fallback is nil, the zero time,
+// zero, unset variables, and an error are returned.
+func (rec *Recording) Replay(fallback System) System {
+	return &replay{rec: rec, fallback: fallback}
+}
+
+type replay struct {
+	rec      *Recording
+	fallback System
+
+	mu           sync.Mutex
+	times, rands int
+}
+
+func (r *replay) Now() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.times < len(r.rec.Times) {
+		r.times++
+		return r.rec.Times[r.times-1]
+	}
+	if r.fallback == nil {
+		return time.Time{}
+	}
+	return r.fallback.Now()
+}
+
+func (r *replay) Int63() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rands < len(r.rec.Rand) {
+		r.rands++
+		return r.rec.Rand[r.rands-1]
+	}
+	if r.fallback == nil {
+		return 0
+	}
+	return r.fallback.Int63()
+}
+
+func (r *replay) LookupEnv(key string) (string, bool) {
+	if v, ok := r.rec.Env[key]; ok {
+		if v == nil {
+			return "", false
+		}
+		return *v, true
+	}
+	if r.fallback == nil {
+		return "", false
+	}
+	return r.fallback.LookupEnv(key)
+}
+
+func (r *replay) Getwd() (string, error) {
+	if r.rec.Dir != "" {
+		return r.rec.Dir, nil
+	}
+	if r.fallback == nil {
+		return "", errNoDir
+	}
+	return r.fallback.Getwd()
+}
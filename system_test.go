@@ -0,0 +1,110 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/goulash/twikutil"
+	"gopkg.in/twik.v1"
+)
+
+func systemFuncs(s *twik.Scope) twikutil.FuncMap {
+	return twikutil.FuncMap{
+		"unix": twikutil.Fn(func(sys twikutil.Sys) int64 { return sys.Now().Unix() }).
+			Doc("Return the current Unix time."),
+		"rand": func(sys twikutil.Sys, n int64) int64 { return sys.Int63() % n },
+		"home": func(sys twikutil.Sys) (string, bool) { return sys.LookupEnv("HOME") },
+		"user": func(sys twikutil.Sys) (string, bool) { return sys.LookupEnv("TWIK_NO_SUCH_USER") },
+		"dir":  func(sys twikutil.Sys) (string, error) { return sys.Getwd() },
+	}
+}
+
+func TestSystem(z *testing.T) {
+	const code = `(var a (unix)) (var b (unix)) (var c (rand 1000)) (var d (home)) (var e (user)) (var f (dir))`
+	run := func(sys twikutil.System) interface{} {
+		e := twikutil.New(systemFuncs)
+		if sys != nil {
+			e.SetSystem(sys)
+		}
+		if _, err := e.ExecString("test", code); err != nil {
+			z.Fatal(err)
+		}
+		var xs []interface{}
+		for _, k := range []string{"a", "b", "c", "d", "e", "f"} {
+			v, _ := e.Get(k)
+			xs = append(xs, v)
+		}
+		return xs
+	}
+
+	fixed := &twikutil.Fixed{
+		Time: time.Unix(100, 0),
+		Step: time.Second,
+		Env:  map[string]string{"HOME": "/home"},
+		Dir:  "/dir",
+	}
+	got := run(fixed)
+	want := []interface{}{int64(100), int64(101), got.([]interface{})[2], "/home", nil, "/dir"}
+	if !reflect.DeepEqual(got, want) {
+		z.Errorf("fixed: got %v; want %v", got, want)
+	}
+
+	rec := twikutil.Record(twikutil.OS)
+	orig := run(rec)
+	var buf bytes.Buffer
+	if err := rec.Recording().Write(&buf); err != nil {
+		z.Fatal(err)
+	}
+	r, err := twikutil.ReadRecording(&buf)
+	if err != nil {
+		z.Fatal(err)
+	}
+	if got := run(r.Replay(nil)); !reflect.DeepEqual(got, orig) {
+		z.Errorf("replay: got %v; want %v", got, orig)
+	}
+}
+
+func TestSystemFork(z *testing.T) {
+	e := twikutil.New(systemFuncs)
+	e.SetSystem(&twikutil.Fixed{Time: time.Unix(1, 0)})
+	f := e.Fork()
+	f.SetSystem(&twikutil.Fixed{Time: time.Unix(2, 0)})
+	for _, t := range []struct {
+		e    *twikutil.Executer
+		want int64
+	}{{e, 1}, {f, 2}} {
+		if _, err := t.e.ExecString("test", `(var t (unix))`); err != nil {
+			z.Fatal(err)
+		}
+		if v, _ := t.e.Get("t"); v != t.want {
+			z.Errorf("(unix) = %v; want %v", v, t.want)
+		}
+	}
+	if _, ok := e.System().(*twikutil.Fixed); !ok {
+		z.Errorf("System() = %T; want *twikutil.Fixed", e.System())
+	}
+}
+
+func TestSystemFormat(z *testing.T) {
+	e := twikutil.New(systemFuncs)
+	help, err := e.Help("unix")
+	if err != nil {
+		z.Fatal(err)
+	}
+	if want := "unix :: int64\n\nReturn the current Unix time."; help != want {
+		z.Errorf("help = %q; want %q", help, want)
+	}
+	if got, want := twikutil.Format("rand", systemFuncs(nil)["rand"]), "rand :: int64 => int64"; got != want {
+		z.Errorf("Format = %q; want %q", got, want)
+	}
+	explicit := func(sys twikutil.System, n int64) int64 { return sys.Int63() % n }
+	if got, want := twikutil.Format("rand", explicit), "rand :: twikutil.System -> int64 => int64"; got != want {
+		z.Errorf("Format = %q; want %q", got, want)
+	}
+}
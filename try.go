@@ -0,0 +1,128 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil
+
+import (
+	"errors"
+	"fmt"
+
+	"gopkg.in/twik.v1"
+	"gopkg.in/twik.v1/ast"
+)
+
+// ScriptError is an error that a script caught with try. It is the value
+// that the catch clause binds, and can be raised again with raise.
+type ScriptError struct {
+	Err error    // the error, such as a *TypeError
+	Pos Position // where it occurred, if known
+}
+
+func (e *ScriptError) Error() string {
+	if e.Pos.File == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.Pos, e.Err)
+}
+
+func (e *ScriptError) Unwrap() error { return e.Err }
+
+// tryFn implements the try builtin:
+//
+//  (try body ... (catch e handler ...))
+//
+// The body is evaluated in a new scope. If it fails, the handler is
+// evaluated in another new scope, where e is the error as a *ScriptError,
+// and try returns the value of the handler. Without a catch clause, try
+// returns nil if the body fails. Scripts aborted by a Debugger are not
+// caught.
+func (e *Executer) tryFn(s *twik.Scope, args []ast.Node) (interface{}, error) {
+	body, handler := args, []ast.Node(nil)
+	var name string
+	if n := len(args); n > 0 {
+		if l, ok := args[n-1].(*ast.List); ok && isCatch(l) {
+			var sym *ast.Symbol
+			if len(l.Nodes) > 1 {
+				sym, _ = l.Nodes[1].(*ast.Symbol)
+			}
+			if sym == nil {
+				return nil, errors.New("catch must be followed by a symbol for the error")
+			}
+			body, handler, name = args[:n-1], l.Nodes[2:], sym.Name
+		}
+	}
+
+	var v interface{}
+	var err error
+	bs := s.Branch()
+	for _, n := range body {
+		if v, err = bs.Eval(n); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		return v, nil
+	}
+	se := e.scriptError(err)
+	if errors.Is(se.Err, ErrAborted) {
+		return nil, err
+	}
+	if handler == nil {
+		return nil, nil
+	}
+
+	hs := s.Branch()
+	hs.Create(name, se)
+	v = nil
+	for _, n := range handler {
+		if v, err = hs.Eval(n); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// isCatch reports whether l is a catch clause.
+func isCatch(l *ast.List) bool {
+	if len(l.Nodes) == 0 {
+		return false
+	}
+	sym, ok := l.Nodes[0].(*ast.Symbol)
+	return ok && sym.Name == "catch"
+}
+
+// scriptError converts an error from twik into a ScriptError with the
+// position in the original file.
+func (e *Executer) scriptError(err error) *ScriptError {
+	te, ok := e.fset.error(err).(*twik.Error)
+	if !ok {
+		return &ScriptError{Err: err}
+	}
+	pi := te.PosInfo
+	return &ScriptError{Err: te.Err, Pos: Position{pi.Name, pi.Line, pi.Column}}
+}
+
+// raise implements the raise builtin, which fails with the message msg,
+// or with an error caught by try. An error that is raised again is
+// reported at the position of raise.
+func raise(x interface{}) error {
+	switch v := x.(type) {
+	case string:
+		return errors.New(v)
+	case *ScriptError:
+		return v.Err
+	case error:
+		return v
+	}
+	return NewTypeError(x, []string{"string", "error"})
+}
+
+// errorMessage implements the error-message builtin, which returns the
+// message of an error caught by try, without its position.
+func errorMessage(err error) string {
+	if se, ok := err.(*ScriptError); ok {
+		return se.Err.Error()
+	}
+	return err.Error()
+}
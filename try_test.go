@@ -0,0 +1,121 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil_test
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/goulash/pre"
+	"github.com/goulash/twikutil"
+	"gopkg.in/twik.v1"
+)
+
+func tryFuncs(*twik.Scope) twikutil.FuncMap {
+	return twikutil.FuncMap{
+		"double":    func(x int64) int64 { return 2 * x },
+		"read-file": func(path string) ([]byte, error) { return os.ReadFile(path) },
+	}
+}
+
+func TestTry(z *testing.T) {
+	tests := []struct {
+		Code  string
+		Value interface{}
+	}{
+		{`(try (double 2))`, int64(4)},
+		{`(try (double 2) (catch e "failed"))`, int64(4)},
+		{`(try (double "a"))`, nil},
+		{`(try (raise "boom") (catch e (error-message e)))`, "boom"},
+		{`(try (error "boom") (catch e (error-message e)))`, "boom"},
+		{`(try (var x 1) (double "a") (catch e x))`, "undefined symbol: x"},
+		{`(try (read-file "/nonexistent") (catch e "default"))`, "default"},
+		{`(try (try (raise "inner") (catch e (raise e))) (catch e (error-message e)))`, "inner"},
+		{`(try (double "a") (catch e (error-message e)))`, "Incorrect parameter type to function double.\n\n\tGot type string but need type int64.\n\tdouble :: int64 => int64"},
+		{`(try (raise "a") (catch e))`, nil},
+	}
+	for _, t := range tests {
+		e := twikutil.New(tryFuncs)
+		_, err := e.ExecString("test", "(var result "+t.Code+")")
+		var v interface{}
+		if err != nil {
+			v = err.(*twik.Error).Err.Error()
+		} else {
+			v, _ = e.Get("result")
+		}
+		if !reflect.DeepEqual(v, t.Value) {
+			z.Errorf("%s = %#v; want %#v", t.Code, v, t.Value)
+		}
+	}
+
+	for _, code := range []string{`(raise 1)`, `(try (catch 1))`, `(try (raise "a") (catch e (raise e)))`} {
+		if _, err := twikutil.New(tryFuncs).ExecString("test", code); err == nil {
+			z.Errorf("%s: expected error", code)
+		}
+	}
+}
+
+func TestTryError(z *testing.T) {
+	fsys := fstest.MapFS{
+		"main.twik": {Data: []byte("#include \"lib.twik\"\n(var caught (try (f) (catch e e)))\n")},
+		"lib.twik":  {Data: []byte("; f fails\n(func f ()\n  (double \"a\"))\n")},
+	}
+	e := twikutil.New(tryFuncs)
+	e.PreProcessor = pre.New()
+	if _, err := e.ExecFS(fsys, "main.twik"); err != nil {
+		z.Fatal(err)
+	}
+	v, _ := e.Get("caught")
+	se, ok := v.(*twikutil.ScriptError)
+	if !ok {
+		z.Fatalf("caught %T; want *twikutil.ScriptError", v)
+	}
+	if want := (twikutil.Position{File: "lib.twik", Line: 3, Column: 4}); se.Pos != want {
+		z.Errorf("position %v; want %v", se.Pos, want)
+	}
+	var te *twikutil.TypeError
+	if !errors.As(se, &te) {
+		z.Errorf("caught %v; want a TypeError", se.Err)
+	}
+}
+
+func TestTryAbort(z *testing.T) {
+	e := twikutil.New(tryFuncs)
+	d := e.StartDebugger(func(f *twikutil.Frame) twikutil.StepMode {
+		if f.Function == "double" {
+			return twikutil.Abort
+		}
+		return twikutil.StepIn
+	})
+	defer d.Stop()
+	d.Step(twikutil.StepIn)
+	_, err := e.ExecString("test", `(try (double 1) (catch e nil))`)
+	if te, ok := err.(*twik.Error); !ok || te.Err != twikutil.ErrAborted {
+		z.Errorf("err = %v; want %v", err, twikutil.ErrAborted)
+	}
+}
+
+func TestTryInstrumented(z *testing.T) {
+	e := twikutil.New(tryFuncs)
+	c := e.StartCoverage()
+	if _, err := e.ExecString("test", `(var x (try (raise "a") (catch e (double 1))))`); err != nil {
+		z.Fatal(err)
+	}
+	c.Stop()
+	if x, _ := e.Get("x"); x != int64(2) {
+		z.Errorf("x = %v; want 2", x)
+	}
+	var counts []int64
+	for _, b := range c.Blocks() {
+		counts = append(counts, b.Count)
+	}
+	// var, try, raise, and double; the catch clause is not a form.
+	if want := []int64{1, 1, 1, 1}; !reflect.DeepEqual(counts, want) {
+		z.Errorf("counts = %v; want %v", counts, want)
+	}
+}
@@ -26,6 +26,48 @@ func (fm FuncMap) Import(o FuncMap) {
 	}
 }
 
+// ImportStrict imports all functions from o, unless any of them already
+// exist in fm, in which case nothing is imported and a ConflictError
+// listing the conflicting names is returned.
+func (fm FuncMap) ImportStrict(o FuncMap) error {
+	var names []string
+	for k := range o {
+		if _, ok := fm[k]; ok {
+			names = append(names, k)
+		}
+	}
+	if len(names) != 0 {
+		sort.Strings(names)
+		return &ConflictError{names}
+	}
+	fm.Import(o)
+	return nil
+}
+
+// Alias makes the function name available under each of the aliases as
+// well. It is an error if name does not exist or any alias already does.
+func (fm FuncMap) Alias(name string, aliases ...string) error {
+	v, ok := fm[name]
+	if !ok {
+		return fmt.Errorf("cannot alias non-existent function %s", name)
+	}
+	o := make(FuncMap, len(aliases))
+	for _, a := range aliases {
+		o[a] = v
+	}
+	return fm.ImportStrict(o)
+}
+
+// Prefixed returns a copy of fm where each name is prefixed with prefix,
+// for example "fs." to namespace file functions as fs.read, fs.write, etc.
+func (fm FuncMap) Prefixed(prefix string) FuncMap {
+	o := make(FuncMap, len(fm))
+	for k, v := range fm {
+		o[prefix+k] = v
+	}
+	return o
+}
+
 func (fm FuncMap) Export(s *twik.Scope) {
 	for k, v := range fm {
 		if v != nil {
@@ -34,6 +76,11 @@ func (fm FuncMap) Export(s *twik.Scope) {
 	}
 }
 
+// ExportPrefixed exports each function under its name prefixed with prefix.
+func (fm FuncMap) ExportPrefixed(s *twik.Scope, prefix string) {
+	fm.Prefixed(prefix).Export(s)
+}
+
 func (fm FuncMap) Keys() []string {
 	keys := make([]string, 0, len(fm))
 	for k := range fm {
@@ -54,120 +101,196 @@ func (fm FuncMap) FormatList() []string {
 // Since the number of return values is dependent on the function, it does not
 // change, and therefore we can create a function now already to handle the
 // output.
+//
+// If the last result is an error, it is returned as the error when not nil.
+// Of the other results, none is returned as nil and one as the value itself.
+// Two results where the second is a bool are returned as the first if the
+// second is true and as nil otherwise, as for (value, ok). Anything else is
+// returned as a list of the results.
 func funcReturn(name string, f interface{}) func([]reflect.Value) (interface{}, error) {
-	t := reflect.TypeOf(f)
-	out := t.NumOut()
-	switch out {
-	case 0:
-		return func(_ []reflect.Value) (interface{}, error) { return nil, nil }
-	case 1:
-		if t.Out(0).Name() != "error" {
-			return func(vo []reflect.Value) (interface{}, error) {
-				return vo[0].Interface(), nil
-			}
-		} else {
-			return func(vo []reflect.Value) (interface{}, error) {
-				if err, ok := vo[0].Interface().(error); ok {
-					if e, ok := err.(*TypeError); ok {
-						e.setFn(name, f)
-						return nil, e
-					}
-					return nil, err
-				}
-				return nil, nil
-			}
-		}
-	case 2:
-		if t.Out(1).Name() != "error" {
-			panic("Func: second return value of f can only be an error")
-		}
-		return func(vo []reflect.Value) (interface{}, error) {
-			v := vo[0].Interface()
-			if err, ok := vo[0].Interface().(error); ok {
+	t := reflect.TypeOf(fnOf(f))
+	out, hasErr := results(t)
+	return func(vo []reflect.Value) (interface{}, error) {
+		if hasErr {
+			if err, ok := vo[out].Interface().(error); ok {
 				if e, ok := err.(*TypeError); ok {
 					e.setFn(name, f)
 					return nil, e
 				}
-				return v, err
+				return nil, err
 			}
-			return v, nil
 		}
-	default:
-		panic("Func: f can only return at most two values")
+		switch {
+		case out == 0:
+			return nil, nil
+		case out == 1:
+			return vo[0].Interface(), nil
+		case isOk(t, out):
+			if !vo[1].Bool() {
+				return nil, nil
+			}
+			return vo[0].Interface(), nil
+		default:
+			xs := make([]interface{}, out)
+			for i := range xs {
+				xs[i] = vo[i].Interface()
+			}
+			return xs, nil
+		}
 	}
 }
 
-func funcVariadic(name string, f interface{}) func([]interface{}) (interface{}, error) {
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// results returns the number of results of the function type t, not
+// counting a last error result, and whether there is one.
+func results(t reflect.Type) (n int, hasErr bool) {
+	n = t.NumOut()
+	if n > 0 && t.Out(n-1) == errorType {
+		return n - 1, true
+	}
+	return n, false
+}
+
+// isOk reports whether the first n results of t follow the (value, ok)
+// convention.
+func isOk(t reflect.Type, n int) bool {
+	return n == 2 && t.Out(1).Kind() == reflect.Bool
+}
+
+func funcVariadic(name string, f interface{}, late func(error)) func([]interface{}) (interface{}, error) {
 	ret := funcReturn(name, f)
-	t := reflect.TypeOf(f)
+	t := reflect.TypeOf(fnOf(f))
 	in := t.NumIn()
 	last := in - 1
-	vf := reflect.ValueOf(f)
+	vf := reflect.ValueOf(fnOf(f))
 	return func(args []interface{}) (interface{}, error) {
 		n := len(args)
 		if n < last {
 			return nil, newParamError(name, f)
 		}
+		cs := &callState{late: late}
 		vi := make([]reflect.Value, n)
 		for i := 0; i < last; i++ {
-			if !funcAccepts(t.In(i), reflect.TypeOf(args[i])) {
+			v, ok := argValue(cs, t.In(i), args[i])
+			if !ok {
 				return nil, &TypeError{name, f, args[i], []string{typeName(t.In(i))}}
 			}
-			vi[i] = reflect.ValueOf(args[i])
+			vi[i] = v
 		}
 		for i := last; i < n; i++ {
-			if !funcAccepts(t.In(last).Elem(), reflect.TypeOf(args[i])) {
-				return nil, &TypeError{name, f, args[i], []string{typeName(t.In(i))}}
+			v, ok := argValue(cs, t.In(last).Elem(), args[i])
+			if !ok {
+				return nil, &TypeError{name, f, args[i], []string{typeName(t.In(last).Elem())}}
 			}
-			vi[i] = reflect.ValueOf(args[i])
+			vi[i] = v
 		}
-		return ret(vf.Call(vi))
+		return call(cs, vf, vi, ret)
 	}
 }
 
-func funcStandard(name string, f interface{}) func([]interface{}) (interface{}, error) {
+func funcStandard(name string, f interface{}, late func(error)) func([]interface{}) (interface{}, error) {
 	ret := funcReturn(name, f)
-	t := reflect.TypeOf(f)
+	t := reflect.TypeOf(fnOf(f))
 	in := t.NumIn()
-	vi := make([]reflect.Value, in)
-	vf := reflect.ValueOf(f)
+	vf := reflect.ValueOf(fnOf(f))
 	return func(args []interface{}) (interface{}, error) {
 		if len(args) != in {
 			return nil, newParamError(name, f)
 		}
+		cs := &callState{late: late}
+		vi := make([]reflect.Value, in)
 		for i := 0; i < in; i++ {
-			if !funcAccepts(t.In(i), reflect.TypeOf(args[i])) {
+			v, ok := argValue(cs, t.In(i), args[i])
+			if !ok {
 				return nil, &TypeError{name, f, args[i], []string{typeName(t.In(i))}}
 			}
-			vi[i] = reflect.ValueOf(args[i])
+			vi[i] = v
 		}
-		return ret(vf.Call(vi))
+		return call(cs, vf, vi, ret)
 	}
 }
 
-func funcAccepts(ft, it reflect.Type) bool {
-	if ft.Kind() == reflect.Interface {
-		return it.Implements(ft)
+// argValue converts the argument x to a value of the parameter type ft,
+// and reports whether this is possible. The rules are:
+//
+//  - nil is accepted for pointers, slices, maps, functions, channels,
+//    and interfaces, and is passed as the zero value of ft.
+//  - A value of type T is accepted for an interface that T implements.
+//  - A value of type T is accepted for *T; a pointer to a copy of the
+//    value is passed, so the function cannot modify the value of x.
+//  - A non-nil *T is accepted for T; the value it points to is passed.
+//  - A twik function is accepted for any function type; see callback.
+//
+// Other values must have exactly the type ft. Callbacks belong to the
+// call cs, which may be nil if the value is not passed to a function.
+func argValue(cs *callState, ft reflect.Type, x interface{}) (reflect.Value, bool) {
+	if x == nil {
+		switch ft.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Func, reflect.Chan, reflect.Interface:
+			return reflect.Zero(ft), true
+		}
+		return reflect.Value{}, false
 	}
-	return ft == it
+	v := reflect.ValueOf(x)
+	it := v.Type()
+	switch {
+	case it == ft:
+		return v, true
+	case ft.Kind() == reflect.Interface && it.Implements(ft):
+		return v, true
+	case ft.Kind() == reflect.Ptr && ft.Elem() == it:
+		p := reflect.New(it)
+		p.Elem().Set(v)
+		return p, true
+	case it.Kind() == reflect.Ptr && it.Elem() == ft && !v.IsNil():
+		return v.Elem(), true
+	case ft.Kind() == reflect.Func && it == twikFuncType:
+		return callback(cs, ft, x.(func([]interface{}) (interface{}, error))), true
+	}
+	return reflect.Value{}, false
 }
 
+// Func adapts the Go function f so that it can be called by scripts.
+//
+// Leading parameters of type Sys, Stdout, and Stderr are implicit: scripts
+// do not pass them, and f is given OS, os.Stdout, and os.Stderr instead,
+// or the System and output of the Executer that f is exported to. Only
+// these types opt in; parameters of type System or io.Writer are passed
+// by scripts like any other.
 func Func(name string, f interface{}) func([]interface{}) (interface{}, error) {
-	t := reflect.TypeOf(f)
+	return adapt(name, f, nil)
+}
+
+// adapt is Func, except that errors of callbacks after f has returned are
+// passed to late if it is not nil.
+func adapt(name string, f interface{}, late func(error)) func([]interface{}) (interface{}, error) {
+	f = defaultImplicit.bind(f)
+	if fs, ok := f.(Overloads); ok {
+		return funcOverloaded(name, fs, late)
+	}
+	t := reflect.TypeOf(fnOf(f))
 	if t.Kind() != reflect.Func {
 		panic("Func: f must be a function")
 	}
 
+	if fn, ok := f.(*Function); ok && fn.options != nil {
+		return funcKeywords(name, fn, late)
+	}
 	if t.IsVariadic() {
-		return funcVariadic(name, f)
+		return funcVariadic(name, f, late)
 	}
-	return funcStandard(name, f)
+	return funcStandard(name, f, late)
 }
 
 func Format(name string, v interface{}) string {
+	v = defaultImplicit.bind(v)
+	if fs, ok := v.(Overloads); ok {
+		return formatOverloads(name, fs)
+	}
 	var buf bytes.Buffer
 	buf.WriteString(name)
-	t := reflect.TypeOf(v)
+	t := reflect.TypeOf(fnOf(v))
 
 	// If it's not of type function, then just print the type.
 	// We differentiate from functions by only printing one colon.
@@ -178,10 +301,25 @@ func Format(name string, v interface{}) string {
 	}
 
 	buf.WriteString(" :: ")
+	var params []string
+	var opts *options
+	if fn, ok := v.(*Function); ok {
+		params = fn.params
+		opts = fn.options
+	}
 	in := t.NumIn()
 	last := in - 1
 	for i := 0; i < in; i++ {
 		it := t.In(i)
+		if i == last && opts != nil {
+			formatOptions(&buf, opts)
+			buf.WriteString(" => ")
+			break
+		}
+		if i < len(params) && params[i] != "" {
+			buf.WriteString(params[i])
+			buf.WriteString(":")
+		}
 		if i == last {
 			if t.IsVariadic() {
 				buf.WriteString(variadicName(it))
@@ -195,36 +333,24 @@ func Format(name string, v interface{}) string {
 		}
 	}
 
-	out := t.NumOut()
-	invalidRetval := func() {
+	out, _ := results(t)
+	switch {
+	case out == 0:
+		buf.WriteString("()")
+	case out == 1:
+		buf.WriteString(typeName(t.Out(0)))
+	case isOk(t, out):
+		buf.WriteString(typeName(t.Out(0)))
+		buf.WriteString("|nil")
+	default:
+		buf.WriteString("[")
 		for i := 0; i < out; i++ {
-			buf.WriteString(typeName(t.Out(i)))
-			if i != out-1 {
-				buf.WriteString(" -> ")
+			if i > 0 {
+				buf.WriteString(" ")
 			}
+			buf.WriteString(typeName(t.Out(i)))
 		}
-	}
-	if out == 0 {
-		buf.WriteString("()")
-	} else if out == 1 {
-		if t.Out(0).Name() == "error" {
-			buf.WriteString("()")
-		} else {
-			buf.WriteString(typeName(t.Out(0)))
-		}
-	} else if out == 2 {
-		if t.Out(0).Name() == "error" {
-			// Invalid: first return value cannot be error
-			invalidRetval()
-		} else if t.Out(1).Name() != "error" {
-			// Invalid: second return value can only be error
-			invalidRetval()
-		} else {
-			buf.WriteString(typeName(t.Out(0)))
-		}
-	} else {
-		// Invalid function:
-		invalidRetval()
+		buf.WriteString("]")
 	}
 	return buf.String()
 }
@@ -274,7 +400,7 @@ func (e TypeError) Error() string {
 	buf.WriteString("Incorrect parameter type to function ")
 	buf.WriteString(e.Name)
 	buf.WriteString(".\n\n\tGot type ")
-	buf.WriteString(typeName(reflect.TypeOf(e.Got)))
+	buf.WriteString(typeOf(e.Got))
 	buf.WriteString(" but need type ")
 	switch len(e.Want) {
 	case 1:
@@ -297,6 +423,15 @@ func (e TypeError) Error() string {
 	return buf.String()
 }
 
+// ConflictError is returned when function names are defined more than once.
+type ConflictError struct {
+	Names []string
+}
+
+func (e ConflictError) Error() string {
+	return "conflicting function names: " + strings.Join(e.Names, ", ")
+}
+
 func newParamError(name string, v interface{}) error {
 	return fmt.Errorf("Incorrect number of parameters to function %s.\n\n\t%s.", name, Format(name, v))
 }
@@ -5,12 +5,19 @@
 package twikutil_test
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/goulash/twikutil"
+	"gopkg.in/twik.v1"
 )
 
 type formatTest struct {
@@ -34,9 +41,15 @@ func TestFormat(z *testing.T) {
 		{time.Now, "a :: time.Time"},
 		{ioutil.ReadAll, "a :: io.Reader => []byte"},
 		{ioutil.NopCloser, "a :: io.Reader => io.ReadCloser"},
-		{ioutil.WriteFile, "a :: string -> []byte -> os.FileMode => ()"},
+		{ioutil.WriteFile, "a :: string -> []byte -> fs.FileMode => ()"},
 		{ioutil.ReadFile, "a :: string => []byte"},
+		{os.LookupEnv, "a :: string => string|nil"},
+		{net.SplitHostPort, "a :: string => [string string]"},
+		{func() (int64, string, bool) { return 0, "", false }, "a :: [int64 string bool]"},
 		{testfn1, "a :: ...{} => []{}"},
+		{twikutil.Fn(ioutil.WriteFile).Params("path", "data", "mode"), "a :: path:string -> data:[]byte -> mode:fs.FileMode => ()"},
+		{twikutil.Fn(fmt.Printf).Params("format", "args"), "a :: format:string -> args:...{} => int"},
+		{twikutil.Fn(ioutil.ReadFile).Doc("Read a file."), "a :: string => []byte"},
 	}
 
 	for _, t := range tests {
@@ -48,5 +61,284 @@ func TestFormat(z *testing.T) {
 
 func TestFuncPrintf(z *testing.T) {
 	// This should just compile and run without any panics.
-	_, _ = twikutil.Func("printf", fmt.Fprintf)([]interface{}{ioutil.Discard, "%s %s!\n", "Hello", "world"})
+	_, err := twikutil.Func("printf", fmt.Fprintf)([]interface{}{ioutil.Discard, "%s %s!\n", "Hello", "world"})
+	if err != nil {
+		z.Error(err)
+	}
+}
+
+func TestFuncMapImportStrict(z *testing.T) {
+	fm := twikutil.FuncMap{"a": time.Now, "b": time.Now}
+	err := fm.ImportStrict(twikutil.FuncMap{"b": time.Now, "c": time.Now, "a": time.Now})
+	if e, ok := err.(*twikutil.ConflictError); !ok || fmt.Sprint(e.Names) != "[a b]" {
+		z.Errorf("ImportStrict error = %v; want conflict on a, b", err)
+	}
+	if _, ok := fm["c"]; ok {
+		z.Error("ImportStrict imported despite conflict")
+	}
+	if err := fm.Alias("a", "x", "y"); err != nil {
+		z.Error(err)
+	}
+	if err := fm.Alias("a", "b"); err == nil {
+		z.Error("Alias overwrote existing function")
+	}
+	keys := fmt.Sprint(fm.Prefixed("t.").Keys())
+	if keys != "[t.a t.b t.x t.y]" {
+		z.Errorf("Prefixed keys = %s", keys)
+	}
+}
+
+func TestFunctionErrors(z *testing.T) {
+	f := twikutil.Func("read-file", twikutil.Fn(ioutil.ReadFile).Params("path"))
+	_, err := f([]interface{}{int64(1)})
+	if err == nil || !strings.Contains(err.Error(), "read-file :: path:string => []byte") {
+		z.Errorf("unexpected type error: %v", err)
+	}
+	_, err = f(nil)
+	if err == nil || !strings.Contains(err.Error(), "read-file :: path:string => []byte") {
+		z.Errorf("unexpected parameter error: %v", err)
+	}
+}
+
+func TestOverload(z *testing.T) {
+	add := twikutil.Overload(
+		func(a, b int64) int64 { return a + b },
+		func(a, b float64) float64 { return a + b },
+		func(a string, bs ...string) string { return a + strings.Join(bs, "") },
+	)
+	f := twikutil.Func("add", add)
+	tests := []struct {
+		Args  []interface{}
+		Value interface{}
+	}{
+		{[]interface{}{int64(1), int64(2)}, int64(3)},
+		{[]interface{}{1.5, 2.0}, 3.5},
+		{[]interface{}{"a", "b", "c"}, "abc"},
+		{[]interface{}{"a"}, "a"},
+	}
+	for _, t := range tests {
+		if v, err := f(t.Args); err != nil || v != t.Value {
+			z.Errorf("add%v = %v, %v; want %v", t.Args, v, err, t.Value)
+		}
+	}
+
+	_, err := f([]interface{}{int64(1), 2.0})
+	want := "Got type float64 but need type int64.\n\t" +
+		"add :: int64 -> int64 => int64\n\t" +
+		"add :: float64 -> float64 => float64\n\t" +
+		"add :: string -> ...string => string"
+	if err == nil || !strings.HasSuffix(err.Error(), want) {
+		z.Errorf("unexpected error: %v", err)
+	}
+	_, err = f(nil)
+	if err == nil || !strings.HasPrefix(err.Error(), "Incorrect number of parameters") {
+		z.Errorf("unexpected error: %v", err)
+	}
+}
+
+type argTest struct {
+	name string
+	f    interface{}
+	args []interface{}
+	want interface{} // result, or nil if an error is expected
+}
+
+type point struct{ X, Y int64 }
+
+func (p point) String() string { return fmt.Sprint(p.X, ",", p.Y) }
+
+func TestFuncArgs(z *testing.T) {
+	var (
+		p    = point{1, 2}
+		np   *point
+		anyf = func(x interface{}) bool { return x == nil }
+		errf = func(err error) bool { return err == nil }
+	)
+	tests := []argTest{
+		// nil for nilable types
+		{"nil pointer", func(p *point) bool { return p == nil }, []interface{}{nil}, true},
+		{"nil slice", func(xs []interface{}) bool { return xs == nil }, []interface{}{nil}, true},
+		{"nil map", func(m map[string]interface{}) bool { return m == nil }, []interface{}{nil}, true},
+		{"nil func", func(f func()) bool { return f == nil }, []interface{}{nil}, true},
+		{"nil chan", func(c chan int) bool { return c == nil }, []interface{}{nil}, true},
+		{"nil interface", anyf, []interface{}{nil}, true},
+		{"nil error", errf, []interface{}{nil}, true},
+		{"nil stringer", func(s fmt.Stringer) bool { return s == nil }, []interface{}{nil}, true},
+
+		// nil for other types
+		{"nil int64", func(x int64) int64 { return x }, []interface{}{nil}, nil},
+		{"nil string", func(s string) string { return s }, []interface{}{nil}, nil},
+		{"nil struct", func(p point) point { return p }, []interface{}{nil}, nil},
+
+		// interfaces
+		{"interface", anyf, []interface{}{int64(1)}, false},
+		{"implements", func(s fmt.Stringer) string { return s.String() }, []interface{}{p}, "1,2"},
+		{"implements pointer", func(s fmt.Stringer) string { return s.String() }, []interface{}{&p}, "1,2"},
+		{"not implements", func(s fmt.Stringer) string { return s.String() }, []interface{}{"x"}, nil},
+
+		// addressing and dereferencing
+		{"exact pointer", func(q *point) bool { return q == &p }, []interface{}{&p}, true},
+		{"address", func(q *point) int64 { q.X = 9; return q.X }, []interface{}{p}, int64(9)},
+		{"dereference", func(q point) int64 { return q.Y }, []interface{}{&p}, int64(2)},
+		{"dereference nil", func(q point) int64 { return q.Y }, []interface{}{np}, nil},
+		{"typed nil pointer", func(q *point) bool { return q == nil }, []interface{}{np}, true},
+		{"pointer to pointer", func(q **point) bool { return q == nil }, []interface{}{p}, nil},
+
+		// variadic
+		{"variadic nil", func(xs ...interface{}) int64 { return int64(len(xs)) }, []interface{}{nil, nil}, int64(2)},
+		{"variadic nil pointer", func(ps ...*point) bool { return ps[0] == nil }, []interface{}{nil}, true},
+		{"variadic nil int64", func(xs ...int64) int64 { return int64(len(xs)) }, []interface{}{int64(1), nil}, nil},
+		{"variadic address", func(ps ...*point) int64 { return ps[0].X }, []interface{}{p}, int64(1)},
+		{"variadic dereference", func(s string, ps ...point) int64 { return ps[1].Y }, []interface{}{"", p, &p}, int64(2)},
+
+		// other types must match exactly
+		{"int", func(x int) int { return x }, []interface{}{int64(1)}, nil},
+		{"float", func(x float64) float64 { return x }, []interface{}{int64(1)}, nil},
+	}
+	for _, t := range tests {
+		got, err := twikutil.Func(t.name, t.f)(t.args)
+		if t.want == nil {
+			if err == nil {
+				z.Errorf("%s: expected error, got %v", t.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			z.Errorf("%s: unexpected error: %v", t.name, err)
+		} else if got != t.want {
+			z.Errorf("%s: got %v; want %v", t.name, got, t.want)
+		}
+	}
+	if p.X != 1 {
+		z.Errorf("function modified argument: %v", p)
+	}
+}
+
+func TestFuncNilTypeError(z *testing.T) {
+	_, err := twikutil.Func("inc", func(x int64) int64 { return x + 1 })([]interface{}{nil})
+	if err == nil || !strings.Contains(err.Error(), "Got type nil but need type int64") {
+		z.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFuncReturn(z *testing.T) {
+	errFail := errors.New("fail")
+	tests := []struct {
+		name string
+		f    interface{}
+		want interface{}
+		err  error
+	}{
+		{"none", func() {}, nil, nil},
+		{"error", func() error { return errFail }, nil, errFail},
+		{"value", func() int64 { return 1 }, int64(1), nil},
+		{"value error", func() (int64, error) { return 1, errFail }, nil, errFail},
+		{"ok", func() (string, bool) { return "a", true }, "a", nil},
+		{"not ok", func() (string, bool) { return "a", false }, nil, nil},
+		{"ok error", func() (string, bool, error) { return "a", true, nil }, "a", nil},
+		{"list", func() (string, int64) { return "a", 1 }, []interface{}{"a", int64(1)}, nil},
+		{"list error", func() (string, string, error) { return "a", "b", nil }, []interface{}{"a", "b"}, nil},
+		{"list fail", func() (string, string, error) { return "a", "b", errFail }, nil, errFail},
+		{"three", func() (string, string, bool) { return "a", "b", false }, []interface{}{"a", "b", false}, nil},
+	}
+	for _, t := range tests {
+		got, err := twikutil.Func(t.name, t.f)(nil)
+		if err != t.err {
+			z.Errorf("%s: error = %v; want %v", t.name, err, t.err)
+		}
+		if !reflect.DeepEqual(got, t.want) {
+			z.Errorf("%s: got %#v; want %#v", t.name, got, t.want)
+		}
+	}
+}
+
+func TestFuncCallback(z *testing.T) {
+	e := twikutil.New(func(s *twik.Scope) twikutil.FuncMap {
+		return twikutil.FuncMap{
+			"apply": func(f func(string) bool, s string) bool { return f(s) },
+			"apply-err": func(f func(int64) (int64, error), x int64) (int64, error) {
+				return f(x)
+			},
+			"lookup": func(f func(string) (int64, bool), k string) int64 {
+				if v, ok := f(k); ok {
+					return v
+				}
+				return -1
+			},
+			"split": func(f func() (string, int64)) string {
+				s, n := f()
+				return fmt.Sprint(s, n)
+			},
+			"pair":  func(a, b interface{}) []interface{} { return []interface{}{a, b} },
+			"sum":   func(f func(...int64) int64) int64 { return f(1, 2, 3) },
+			"twice": func(f func(int64) int64, x int64) int64 { return f(f(x)) },
+		}
+	})
+	tests := []struct {
+		Code  string
+		Value interface{}
+	}{
+		{`(apply (func (s) (== s "a")) "a")`, true},
+		{`(apply-err (func (x) (* x 2)) 2)`, int64(4)},
+		{`(lookup (func (k) (if (== k "a") 1 nil)) "a")`, int64(1)},
+		{`(lookup (func (k) (if (== k "a") 1 nil)) "b")`, int64(-1)},
+		{`(split (func () (pair "a" 1)))`, "a1"},
+		{`(sum +)`, int64(6)},
+		{`(twice (func (x) (twice (func (y) (+ y 1)) x)) 0)`, int64(4)},
+	}
+	for _, t := range tests {
+		e.Set("result", nil)
+		if _, err := e.ExecString("test", "(set result "+t.Code+")"); err != nil {
+			z.Errorf("%s: unexpected error: %v", t.Code, err)
+			continue
+		}
+		if v, _ := e.Get("result"); !reflect.DeepEqual(v, t.Value) {
+			z.Errorf("%s = %#v; want %#v", t.Code, v, t.Value)
+		}
+	}
+
+	errs := []struct {
+		Code string
+		Err  string
+	}{
+		{`(apply (func (s) 1) "a")`, "callback returned type int64 but need type bool"},
+		{`(apply (func () true) "a")`, "anonymous function takes no arguments"},
+		{`(apply-err (func (x) (nil)) 1)`, "cannot use <nil> as a function"},
+		{`(split (func () "a"))`, "callback returned a but need a list of 2 values"},
+		{`(apply "a" "a")`, "need type func(string) bool"},
+	}
+	for _, t := range errs {
+		_, err := e.ExecString("test", t.Code)
+		if err == nil || !strings.Contains(err.Error(), t.Err) {
+			z.Errorf("%s: unexpected error: %v", t.Code, err)
+		}
+	}
+	// A stored callback that fails later must not panic.
+	var handler func(string) bool
+	e = twikutil.New(func(s *twik.Scope) twikutil.FuncMap {
+		return twikutil.FuncMap{
+			"on":   func(f func(string) bool) { handler = f },
+			"fire": func(s string) bool { return handler(s) },
+		}
+	})
+	var stderr bytes.Buffer
+	e.SetOutput(nil, &stderr)
+	if _, err := e.ExecString("test", `(on (func (s) 1))`); err != nil {
+		z.Fatal(err)
+	}
+	if _, err := e.ExecString("test", `(fire "a")`); err != nil {
+		z.Fatal(err)
+	}
+	if !strings.Contains(stderr.String(), "need type bool") {
+		z.Errorf("stderr = %q; want the error of the callback", stderr.String())
+	}
+
+	var failed error
+	e.SetHooks(&twikutil.Hooks{CallbackError: func(err error) { failed = err }})
+	if _, err := e.ExecString("test", `(on (func (s) 1)) (fire "a")`); err != nil {
+		z.Fatal(err)
+	}
+	if failed == nil || !strings.Contains(failed.Error(), "need type bool") {
+		z.Errorf("CallbackError got %v", failed)
+	}
 }
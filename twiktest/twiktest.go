@@ -0,0 +1,234 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+// Package twiktest tests twik scripts against golden files.
+//
+// Each script NAME.twik in a directory is run in a new Executer, and the
+// result is compared with the file NAME.golden next to it:
+//
+//  func TestConfig(t *testing.T) {
+//      twiktest.Run(t, "testdata", loader, km)
+//  }
+//
+// The result consists of the output that the script writes to the
+// standard output and error of the Executer, such as with the printing
+// functions of the stdlib package, the values of the keys if the script succeeds, and
+// otherwise the error it fails with, including its position. Each part
+// that is not empty is written to the golden file in a section:
+//
+//  -- output --
+//  hello
+//  -- keys --
+//  name = "twik"
+//  -- error --
+//  testdata/fail.twik:3:1: undefined symbol: x
+//
+// Run the tests with the -update flag to write the golden files from the
+// current results.
+//
+// Scripts can check their own state with the functions in Assertions.
+// A failed assertion fails the test, whatever the golden file says.
+package twiktest
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/goulash/errs"
+	"github.com/goulash/pre"
+	"github.com/goulash/twikutil"
+	"github.com/goulash/twikutil/key"
+	"gopkg.in/twik.v1"
+)
+
+var update = flag.Bool("update", false, "update the golden files of twik scripts")
+
+// Run runs each script in dir as a subtest of t and compares the result
+// with its golden file. The functions of the scripts are those returned by
+// loader, if it is not nil, and those in Assertions. Before a script runs,
+// a copy of km is applied to the Executer; afterwards the keys are
+// acquired and their values are part of the result.
+func Run(t *testing.T, dir string, loader twikutil.LoaderFunc, km key.KeyMap) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.twik"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no twik scripts in %s", dir)
+	}
+	for _, file := range files {
+		file := file
+		name := strings.TrimSuffix(filepath.Base(file), ".twik")
+		t.Run(name, func(t *testing.T) {
+			got, err := result(file, loader, km)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := strings.TrimSuffix(file, ".twik") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if os.IsNotExist(err) {
+				t.Fatalf("%s does not exist; run with -update to create it", golden)
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("%s differs from %s:\n%s\nwant:\n%s", file, golden, got, want)
+			}
+		})
+	}
+}
+
+// result runs the script file and returns its result in the format of the
+// golden files. It returns an error if an assertion failed.
+func result(file string, loader twikutil.LoaderFunc, km key.KeyMap) (string, error) {
+	var out bytes.Buffer
+	e := twikutil.New(func(s *twik.Scope) twikutil.FuncMap {
+		fm := make(twikutil.FuncMap)
+		if loader != nil {
+			fm.Import(loader(s))
+		}
+		fm.Import(Assertions)
+		return fm
+	})
+	e.PreProcessor = pre.New()
+	e.SetOutput(&out, &out)
+
+	km = km.Clone()
+	if err := km.Apply(e); err != nil {
+		return "", err
+	}
+	_, runErr := e.Exec(file)
+	if isAssertion(runErr) {
+		return "", runErr
+	}
+	var keys bytes.Buffer
+	if runErr == nil {
+		if err := km.Acquire(e, errs.Quit); err != nil {
+			return "", err
+		}
+		for _, name := range km.KeyNames() {
+			fmt.Fprintf(&keys, "%s = %s\n", name, repr(km[name].Get()))
+		}
+	}
+
+	var buf bytes.Buffer
+	section := func(name, text string) {
+		if text == "" {
+			return
+		}
+		fmt.Fprintf(&buf, "-- %s --\n%s", name, text)
+		if !strings.HasSuffix(text, "\n") {
+			buf.WriteByte('\n')
+		}
+	}
+	section("output", out.String())
+	section("keys", keys.String())
+	if runErr != nil {
+		section("error", runErr.Error())
+	}
+	return buf.String(), nil
+}
+
+// repr returns x as it would be written in twik, where possible.
+func repr(x interface{}) string {
+	switch v := x.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return strconv.Quote(v)
+	case []interface{}:
+		xs := make([]string, len(v))
+		for i, y := range v {
+			xs[i] = repr(y)
+		}
+		return "(" + strings.Join(xs, " ") + ")"
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		xs := make([]string, len(keys))
+		for i, k := range keys {
+			xs[i] = strconv.Quote(k) + " " + repr(v[k])
+		}
+		return "(dict " + strings.Join(xs, " ") + ")"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Assertions {{{
+
+// AssertionError is the error of a failed assertion.
+type AssertionError struct {
+	Msg string
+}
+
+func (e *AssertionError) Error() string { return "assertion failed: " + e.Msg }
+
+func isAssertion(err error) bool {
+	if te, ok := err.(*twik.Error); ok {
+		err = te.Err
+	}
+	_, ok := err.(*AssertionError)
+	return ok
+}
+
+// Assertions contains functions that stop a script with an AssertionError
+// if a condition does not hold:
+//
+//  (assert cond [msg])         cond is not false
+//  (assert-equal want got)     want and got are deeply equal
+//  (assert-not-equal a b)      a and b are not deeply equal
+//
+// Run makes them available to all scripts.
+var Assertions = twikutil.FuncMap{
+	"assert": twikutil.Fn(assert).Params("cond", "msg").
+		Doc("Fail the test if cond is false."),
+	"assert-equal": twikutil.Fn(assertEqual).Params("want", "got").
+		Doc("Fail the test if want and got are not equal."),
+	"assert-not-equal": twikutil.Fn(assertNotEqual).Params("a", "b").
+		Doc("Fail the test if a and b are equal."),
+}
+
+func assert(cond interface{}, msg ...interface{}) error {
+	if cond != false {
+		return nil
+	}
+	if len(msg) == 0 {
+		return &AssertionError{"condition is false"}
+	}
+	return &AssertionError{fmt.Sprint(msg...)}
+}
+
+func assertEqual(want, got interface{}) error {
+	if reflect.DeepEqual(want, got) {
+		return nil
+	}
+	return &AssertionError{fmt.Sprintf("got %s, want %s", repr(got), repr(want))}
+}
+
+func assertNotEqual(a, b interface{}) error {
+	if !reflect.DeepEqual(a, b) {
+		return nil
+	}
+	return &AssertionError{fmt.Sprintf("both are %s", repr(a))}
+}
+
+// }}}
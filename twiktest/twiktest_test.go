@@ -0,0 +1,48 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twiktest_test
+
+import (
+	"testing"
+
+	"github.com/goulash/twikutil"
+	"github.com/goulash/twikutil/key"
+	"github.com/goulash/twikutil/stdlib"
+	"github.com/goulash/twikutil/twiktest"
+	"gopkg.in/twik.v1"
+)
+
+func TestRun(z *testing.T) {
+	km := key.NewKeyMap()
+	key.Must(km.CreateAuto("name", "default", key.ReadWrite, "Name of the service."))
+	key.Must(km.CreateAuto("port", int64(8080), key.ReadWrite, "Port to listen on."))
+	twiktest.Run(z, "testdata", func(*twik.Scope) twikutil.FuncMap { return stdlib.All }, km)
+}
+
+func TestAssertions(z *testing.T) {
+	tests := []struct {
+		code string
+		err  string
+	}{
+		{`(assert true)`, ""},
+		{`(assert (== 1 2))`, "assertion failed: condition is false"},
+		{`(assert false "x is " 1)`, "assertion failed: x is 1"},
+		{`(assert-equal "a" "a")`, ""},
+		{`(assert-equal 1 "1")`, `assertion failed: got "1", want 1`},
+		{`(assert-not-equal 1 2)`, ""},
+		{`(assert-not-equal nil nil)`, "assertion failed: both are nil"},
+	}
+	for _, t := range tests {
+		e := twikutil.New(func(*twik.Scope) twikutil.FuncMap { return twiktest.Assertions })
+		_, err := e.ExecString("test", t.code)
+		var got string
+		if err != nil {
+			got = err.(*twik.Error).Err.Error()
+		}
+		if got != t.err {
+			z.Errorf("%s: got error %q; want %q", t.code, got, t.err)
+		}
+	}
+}
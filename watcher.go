@@ -0,0 +1,330 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goulash/pre"
+	past "github.com/goulash/pre/ast"
+)
+
+// An Acquirer prepares a fresh Executer before a watched script is run,
+// and extracts the value that the Watcher should hold after it ran.
+// The key.KeyMap type provides an Acquirer with its Acquirer method.
+type Acquirer interface {
+	// Prepare is called on a fresh Executer before the script is executed.
+	Prepare(e *Executer) error
+
+	// Acquire is called after the script executed successfully. If it
+	// returns an error, the value is not swapped in.
+	Acquire(e *Executer) (interface{}, error)
+}
+
+// Watcher executes a script whenever it or any of the files it includes
+// (via the PreProcessor) change, and atomically swaps in the new value
+// when execution and acquisition succeed.
+//
+// Changes are detected by polling the modification time and size of each
+// file, so a Watcher works on every file system. It does not use the
+// change notifications of the operating system.
+type Watcher struct {
+	// PreProcessor is set on every Executer that the script is run in.
+	PreProcessor *pre.Processor
+
+	// Interval is the time between two polls of the watched files.
+	Interval time.Duration
+
+	// Delay is the time files must remain unchanged after a change before
+	// the script is run again. This debounces editors that write a file in
+	// several steps.
+	Delay time.Duration
+
+	// OnError is called with any error that occurs while reloading.
+	// The previous value remains in place.
+	OnError func(error)
+
+	// OnReload is called with the new value after it has been swapped in.
+	OnReload func(v interface{})
+
+	// Both callbacks are called without any lock held, so they may call
+	// methods of the Watcher.
+
+	path   string
+	loader LoaderFunc
+	acq    Acquirer
+	value  atomic.Value
+
+	mu      sync.Mutex
+	stamps  map[string]fileStamp
+	pending time.Time
+	stop    chan struct{}
+	done    chan struct{}
+	calling uint64 // ID of the polling goroutine while it is in a callback
+}
+
+type fileStamp struct {
+	mod  time.Time
+	size int64
+	ok   bool
+}
+
+// holder lets us store a nil value in an atomic.Value.
+type holder struct {
+	v interface{}
+}
+
+func NewWatcher(path string, loader LoaderFunc, acq Acquirer) *Watcher {
+	return &Watcher{
+		Interval: time.Second,
+		Delay:    100 * time.Millisecond,
+		path:     path,
+		loader:   loader,
+		acq:      acq,
+	}
+}
+
+// Value returns the most recently acquired value, which is nil if the
+// script has not yet been loaded successfully.
+func (w *Watcher) Value() interface{} {
+	h, _ := w.value.Load().(holder)
+	return h.v
+}
+
+// Files returns the sorted list of files currently being watched.
+func (w *Watcher) Files() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	xs := make([]string, 0, len(w.stamps))
+	for k := range w.stamps {
+		xs = append(xs, k)
+	}
+	sort.Strings(xs)
+	return xs
+}
+
+// Load executes the script in a fresh Executer and swaps in the new value
+// if that succeeds. The error is returned and not passed to OnError.
+func (w *Watcher) Load() error {
+	w.mu.Lock()
+	v, err := w.load()
+	onReload := w.OnReload
+	w.mu.Unlock()
+	if err == nil && onReload != nil {
+		onReload(v)
+	}
+	return err
+}
+
+// load runs the script and returns the value that was swapped in.
+func (w *Watcher) load() (interface{}, error) {
+	// We always update the list of files, even on error, so that fixing
+	// a broken include is noticed as well.
+	files := []string{w.path}
+	bs, err := ioutil.ReadFile(w.path)
+	if err == nil && w.PreProcessor != nil {
+		root, _ := w.PreProcessor.ParseString(w.path, string(bs))
+		files = append(files, includes(w.PreProcessor, w.path, string(bs), root)...)
+	}
+	w.stamps = make(map[string]fileStamp)
+	for _, f := range files {
+		w.stamps[f] = stat(f)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e := New(w.loader)
+	e.PreProcessor = w.PreProcessor
+	if err = w.acq.Prepare(e); err != nil {
+		return nil, err
+	}
+	if _, err = e.ExecString(w.path, string(bs)); err != nil {
+		return nil, err
+	}
+	v, err := w.acq.Acquire(e)
+	if err != nil {
+		return nil, err
+	}
+	w.value.Store(holder{v})
+	return v, nil
+}
+
+// notify calls OnReload or OnError, which were read under the lock,
+// with the result of load.
+func notify(onReload func(interface{}), onError func(error), v interface{}, err error) {
+	if err != nil {
+		if onError != nil {
+			onError(err)
+		}
+	} else if onReload != nil {
+		onReload(v)
+	}
+}
+
+// Poll checks the watched files for changes once, and reloads the script
+// when a change has settled for at least Delay. It reports whether the
+// script was run. Poll is called periodically after Start, but it can also
+// be called by hand.
+func (w *Watcher) Poll() bool {
+	return w.poll(false)
+}
+
+func (w *Watcher) poll(loop bool) bool {
+	w.mu.Lock()
+	now := time.Now()
+	for f, s := range w.stamps {
+		if t := stat(f); t != s {
+			w.pending = now
+			w.stamps[f] = t
+		}
+	}
+	if w.pending.IsZero() || now.Sub(w.pending) < w.Delay {
+		w.mu.Unlock()
+		return false
+	}
+	w.pending = time.Time{}
+	v, err := w.load()
+	onReload, onError := w.OnReload, w.OnError
+	w.mu.Unlock()
+
+	if loop {
+		atomic.StoreUint64(&w.calling, goid())
+		defer atomic.StoreUint64(&w.calling, 0)
+	}
+	notify(onReload, onError, v, err)
+	return true
+}
+
+// Start polls the watched files every Interval in a separate goroutine
+// until Stop is called. If the script has not been loaded yet, Start
+// first tries to load it, reporting errors to OnError.
+func (w *Watcher) Start() {
+	w.mu.Lock()
+	if w.stop != nil {
+		w.mu.Unlock()
+		return
+	}
+	loaded := w.stamps == nil
+	var v interface{}
+	var err error
+	if loaded {
+		v, err = w.load()
+	}
+	onReload, onError := w.OnReload, w.OnError
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	w.mu.Unlock()
+
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		t := time.NewTicker(w.Interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				w.poll(true)
+			}
+		}
+	}(w.stop, w.done)
+
+	if loaded {
+		notify(onReload, onError, v, err)
+	}
+}
+
+// Stop stops polling and waits until the polling goroutine has quit.
+// When it is called from a callback of the polling goroutine, it cannot
+// wait for it, and returns at once; the goroutine quits after the callback.
+// Callbacks of the polling goroutine are never called after Stop returns.
+func (w *Watcher) Stop() {
+	w.mu.Lock()
+	stop, done := w.stop, w.done
+	w.stop, w.done = nil, nil
+	w.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		if atomic.LoadUint64(&w.calling) != goid() {
+			<-done
+		}
+	}
+}
+
+// goid returns the ID of the calling goroutine, so that Stop can tell
+// whether it is called from a callback of the polling goroutine.
+func goid() uint64 {
+	var buf [64]byte
+	s := strings.TrimPrefix(string(buf[:runtime.Stack(buf[:], false)]), "goroutine ")
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		s = s[:i]
+	}
+	id, _ := strconv.ParseUint(s, 10, 64)
+	return id
+}
+
+func stat(path string) fileStamp {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{fi.ModTime(), fi.Size(), true}
+}
+
+// includes returns the names of all files that the script at path
+// includes with p, directly or indirectly, including those that
+// contribute no text, such as files that are empty or only define macros.
+//
+// The root node only has the text of the included files, so we follow
+// the include commands of each file ourselves, resolving them as the pre
+// package does. Files included by commands that we do not recognise are
+// taken from the root node.
+func includes(p *pre.Processor, path, code string, root past.Node) []string {
+	seen := map[string]bool{path: true}
+	var xs []string
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			xs = append(xs, name)
+		}
+	}
+	var follow func(name, code string, depth int)
+	follow = func(name, code string, depth int) {
+		if depth >= p.MaxIncludeDepth {
+			return
+		}
+		for _, line := range strings.Split(code, "\n") {
+			_, arg, _, ok := includeCommand(p.Trigger, line)
+			if !ok {
+				continue
+			}
+			target := filepath.Join(filepath.Dir(name), arg)
+			if seen[target] {
+				continue
+			}
+			add(target)
+			if bs, err := ioutil.ReadFile(target); err == nil {
+				follow(target, string(bs), depth+1)
+			}
+		}
+	}
+	follow(path, code, 0)
+	if fn, ok := root.(*past.FileNode); ok {
+		for _, n := range fn.Nodes() {
+			add(n.Pos().Name)
+		}
+	}
+	return xs
+}
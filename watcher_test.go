@@ -0,0 +1,202 @@
+// Copyright (c) 2015, Ben Morgan. All rights reserved.
+// Use of this source code is governed by an MIT license
+// that can be found in the LICENSE file.
+
+package twikutil_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goulash/errs"
+	"github.com/goulash/pre"
+	"github.com/goulash/twikutil"
+	"github.com/goulash/twikutil/key"
+	"gopkg.in/twik.v1"
+)
+
+func noFuncs(_ *twik.Scope) twikutil.FuncMap { return nil }
+
+func TestWatcher(z *testing.T) {
+	dir, err := ioutil.TempDir("", "twikutil")
+	if err != nil {
+		z.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	main := filepath.Join(dir, "main.twik")
+	inc := filepath.Join(dir, "inc.twik")
+	write := func(path, s string) {
+		if err := ioutil.WriteFile(path, []byte(s), 0644); err != nil {
+			z.Fatal(err)
+		}
+	}
+	write(main, "#include \"inc.twik\"\n(set name \"main\")\n")
+	write(inc, "(set port 80)\n")
+
+	km := key.NewKeyMap()
+	key.Must(km.CreateAuto("name", "", key.ReadWrite, ""))
+	key.Must(km.CreateAuto("port", int64(0), key.ReadWrite, ""))
+
+	w := twikutil.NewWatcher(main, noFuncs, km.Acquirer(errs.Quit))
+	w.PreProcessor = pre.New()
+	w.Delay = 0
+	var errors []error
+	w.OnError = func(err error) { errors = append(errors, err) }
+	if err := w.Load(); err != nil {
+		z.Fatal(err)
+	}
+	if n := len(w.Files()); n != 2 {
+		z.Fatalf("watching %d files; want 2", n)
+	}
+	port := func() interface{} { return w.Value().(key.KeyMap)["port"].Get() }
+	if p := port(); p != int64(80) {
+		z.Errorf("port = %v; want 80", p)
+	}
+
+	write(inc, "(set port 8080)\n")
+	if !w.Poll() {
+		z.Fatal("Poll did not notice change of included file")
+	}
+	if p := port(); p != int64(8080) {
+		z.Errorf("port = %v; want 8080", p)
+	}
+
+	// A broken script must not replace the current value.
+	write(inc, "(set port \"broken\")\n")
+	w.Poll()
+	if len(errors) != 1 {
+		z.Errorf("got %d errors; want 1", len(errors))
+	}
+	if p := port(); p != int64(8080) {
+		z.Errorf("port = %v; want 8080", p)
+	}
+	if km["port"].Get() != int64(0) {
+		z.Errorf("original key map was modified")
+	}
+}
+
+func TestWatcherCallbacks(z *testing.T) {
+	dir, err := ioutil.TempDir("", "twikutil")
+	if err != nil {
+		z.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	main := filepath.Join(dir, "main.twik")
+	if err := ioutil.WriteFile(main, []byte("(set port 80)\n"), 0644); err != nil {
+		z.Fatal(err)
+	}
+	km := key.NewKeyMap()
+	key.Must(km.CreateAuto("port", int64(0), key.ReadWrite, ""))
+
+	// Callbacks that use the Watcher must not deadlock.
+	w := twikutil.NewWatcher(main, noFuncs, km.Acquirer(errs.Quit))
+	w.Interval = time.Millisecond
+	w.Delay = 0
+	reloaded := make(chan int, 2)
+	w.OnReload = func(interface{}) {
+		reloaded <- len(w.Files())
+		w.Stop()
+	}
+	w.Start()
+	if err := ioutil.WriteFile(main, []byte("(set port 8080)\n\n"), 0644); err != nil {
+		z.Fatal(err)
+	}
+	w.Poll()
+	for i := 0; i < 2; i++ {
+		select {
+		case n := <-reloaded:
+			if n != 1 {
+				z.Errorf("watching %d files; want 1", n)
+			}
+		case <-time.After(5 * time.Second):
+			z.Fatal("callback deadlocked")
+		}
+	}
+}
+
+func TestWatcherIncludes(z *testing.T) {
+	dir, err := ioutil.TempDir("", "twikutil")
+	if err != nil {
+		z.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, s string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(s), 0644); err != nil {
+			z.Fatal(err)
+		}
+	}
+	write("main.twik", "#include \"defs.twik\"\n#include \"empty.twik\"\n")
+	write("defs.twik", "#include \"port.twik\"\n")
+	write("port.twik", "(set port 80)\n")
+	write("other.twik", "(set port 8080)\n")
+	write("empty.twik", "")
+
+	km := key.NewKeyMap()
+	key.Must(km.CreateAuto("port", int64(0), key.ReadWrite, ""))
+	w := twikutil.NewWatcher(filepath.Join(dir, "main.twik"), noFuncs, km.Acquirer(errs.Quit))
+	w.PreProcessor = pre.New()
+	w.Delay = 0
+	if err := w.Load(); err != nil {
+		z.Fatal(err)
+	}
+	if n := len(w.Files()); n != 4 {
+		z.Fatalf("watching %v; want 4 files", w.Files())
+	}
+	write("defs.twik", "#include \"other.twik\"\n")
+	if !w.Poll() {
+		z.Fatal("Poll did not notice change of file that only includes others")
+	}
+	if p := w.Value().(key.KeyMap)["port"].Get(); p != int64(8080) {
+		z.Errorf("port = %v; want 8080", p)
+	}
+}
+
+func TestWatcherStop(z *testing.T) {
+	dir, err := ioutil.TempDir("", "twikutil")
+	if err != nil {
+		z.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	main := filepath.Join(dir, "main.twik")
+	if err := ioutil.WriteFile(main, []byte("(set port 80)\n"), 0644); err != nil {
+		z.Fatal(err)
+	}
+	km := key.NewKeyMap()
+	key.Must(km.CreateAuto("port", int64(0), key.ReadWrite, ""))
+	w := twikutil.NewWatcher(main, noFuncs, km.Acquirer(errs.Quit))
+	w.Interval = time.Millisecond
+	w.Delay = 0
+	if err := w.Load(); err != nil {
+		z.Fatal(err)
+	}
+
+	// Stop called by another goroutine must wait for a running callback.
+	started := make(chan struct{})
+	var finished int32
+	w.OnReload = func(interface{}) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+	}
+	w.Start()
+	if err := ioutil.WriteFile(main, []byte("(set port 8080)\n\n"), 0644); err != nil {
+		z.Fatal(err)
+	}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		z.Fatal("watcher did not reload")
+	}
+	w.Stop()
+	if atomic.LoadInt32(&finished) == 0 {
+		z.Error("Stop returned while a callback was running")
+	}
+}